@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+const (
+	// baselineVersion is the current version of the baseline format.
+	// Version 1 baselines have no unit suffix flag.
+	baselineVersion = 2
+
+	// defaultZScore is the default z-score above which results are
+	// anomalous.
+	defaultZScore = 3.0
+
+	// defaultMaxRows is the default number of anomalous rows reported.
+	defaultMaxRows = 1000
+)
+
+var errBaselineFormat = errors.New("bad baseline format")
+
+// moments holds the count, mean and sum of squared deviations from the mean of
+// a station's values in units of 10^-scale, along with their extremes.
+// moments can be merged so they can be computed in parallel.
+type moments struct {
+	count    int
+	mean, m2 float64
+	min, max int
+}
+
+// add adds a value.
+func (s *moments) add(num int) {
+	if s.count == 0 || num < s.min {
+		s.min = num
+	}
+	if s.count == 0 || num > s.max {
+		s.max = num
+	}
+	s.count++
+	d := float64(num) - s.mean
+	s.mean += d / float64(s.count)
+	s.m2 += d * (float64(num) - s.mean)
+}
+
+// merge merges o into s.
+func (s *moments) merge(o *moments) {
+	switch {
+	case o.count == 0:
+		return
+	case s.count == 0:
+		*s = *o
+		return
+	}
+	if o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+	n := float64(s.count + o.count)
+	d := o.mean - s.mean
+	s.m2 += o.m2 + d*d*float64(s.count)*float64(o.count)/n
+	s.mean += d * float64(o.count) / n
+	s.count += o.count
+}
+
+// stddev returns the sample standard deviation.
+func (s *moments) stddev() float64 {
+	if s.count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.count-1))
+}
+
+// baselineStation is the historical baseline for a station. Values are in
+// degrees and Min and Max are rounded to the output scale of the baseline.
+type baselineStation struct {
+	Name   string  `json:"name"`
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// baseline holds the historical baselines for stations.
+type baseline struct {
+	Version int `json:"version"`
+
+	// Scale is the number of fractional digits of the values the baseline
+	// was computed from.
+	Scale int `json:"scale"`
+
+	// UnitSuffix indicates that values were parsed with unit suffixes and
+	// have two more fractional digits than results are written with. See
+	// Options.valueScale.
+	UnitSuffix bool `json:"unit_suffix,omitempty"`
+
+	Stations []baselineStation `json:"stations"`
+}
+
+// outputScale returns the number of fractional digits results are written
+// with.
+func (b *baseline) outputScale() int {
+	if b.UnitSuffix {
+		return b.Scale - 2
+	}
+	return b.Scale
+}
+
+// stations returns the baselines keyed by station name.
+func (b *baseline) stations() map[string]*baselineStation {
+	m := make(map[string]*baselineStation, len(b.Stations))
+	for i := range b.Stations {
+		m[b.Stations[i].Name] = &b.Stations[i]
+	}
+	return m
+}
+
+// computeBaseline computes the baseline for the file at path.
+func computeBaseline(path string, opts *Options) (*baseline, error) {
+	switch {
+	case opts.Window > 0:
+		return nil, errors.New("baselines cannot be computed for windowed results")
+	case opts.unit() != unitCelsius:
+		return nil, errors.New("baselines can only be computed in Celsius")
+	}
+
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	parsers := make([]*parser, runtime.GOMAXPROCS(0))
+	results := make([]map[string]*moments, len(parsers))
+	for i := range parsers {
+		parsers[i] = newParser(opts)
+		results[i] = make(map[string]*moments)
+	}
+	err = scanDataRandom(data, segmentSize, parsers, func(i int, chunk []byte, offset int64) error {
+		m := results[i]
+		return parsers[i].eachLine(chunk, offset, func(name []byte, num int, _ int64) {
+			s, ok := m[string(name)]
+			if !ok {
+				s = &moments{}
+				m[string(name)] = s
+			}
+			s.add(num)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := results[0]
+	for _, m := range results[1:] {
+		for name, s := range m {
+			if t, ok := merged[name]; ok {
+				t.merge(s)
+			} else {
+				merged[name] = s
+			}
+		}
+	}
+
+	b := &baseline{
+		Version:    baselineVersion,
+		Scale:      opts.valueScale(),
+		UnitSuffix: opts.UnitSuffix,
+	}
+	div, scale := math.Pow10(b.Scale), b.outputScale()
+	for name, s := range merged {
+		b.Stations = append(b.Stations, baselineStation{
+			Name:   name,
+			Count:  s.count,
+			Mean:   s.mean / div,
+			StdDev: s.stddev() / div,
+			Min:    roundScale(float64(s.min)/div, scale),
+			Max:    roundScale(float64(s.max)/div, scale),
+		})
+	}
+	sort.Slice(b.Stations, func(i, j int) bool {
+		return b.Stations[i].Name < b.Stations[j].Name
+	})
+	return b, nil
+}
+
+// writeBaseline writes the baseline b to w.
+func writeBaseline(w io.Writer, b *baseline) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// readBaselineFile reads a baseline from the file at path.
+func readBaselineFile(path string) (*baseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var b baseline
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&b); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, errBaselineFormat, err)
+	}
+	if b.Version < 1 || b.Version > baselineVersion {
+		return nil, fmt.Errorf("%s: %w: unsupported version %d", path, errBaselineFormat, b.Version)
+	}
+	if s := b.outputScale(); s < 1 || s > maxScale {
+		return nil, fmt.Errorf("%s: %w: invalid scale %d", path, errBaselineFormat, b.Scale)
+	}
+	return &b, nil
+}
+
+// Kinds of anomalies.
+const (
+	anomalyStation = "station"
+	anomalyRow     = "row"
+)
+
+// anomaly is a station result or row that deviates from the station's
+// baseline.
+type anomaly struct {
+	Kind    string `json:"kind"`
+	Station string `json:"station"`
+
+	// Field is the anomalous result field for station anomalies: "mean",
+	// "min" or "max".
+	Field string `json:"field,omitempty"`
+
+	Value json.Number `json:"value"`
+
+	// ZScore is the number of baseline standard deviations between Value
+	// and the baseline mean for station anomalies.
+	ZScore float64 `json:"zscore,omitempty"`
+
+	// Offset is the byte offset of the start of the line for row anomalies.
+	Offset *int64 `json:"offset,omitempty"`
+
+	// Min and Max are the baseline range for row anomalies.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// zscore returns the number of standard deviations between v and the mean of
+// the baseline.
+func zscore(v float64, base *baselineStation) float64 {
+	return (v - base.Mean) / base.StdDev
+}
+
+// stationAnomalies returns the stations in m whose mean, min or max, as written
+// in the results, are more than threshold standard deviations from the
+// baseline mean, ordered by name. Stations without a baseline or with no
+// deviation in their baseline are ignored.
+func stationAnomalies(m map[string]*TempInfo, b *baseline, threshold float64) []anomaly {
+	base := b.stations()
+
+	var anomalies []anomaly
+	for name, info := range m {
+		s, ok := base[name]
+		if !ok || s.StdDev == 0 {
+			continue
+		}
+		r := newColumnResult(name, info, b.Scale, b.outputScale(), unitCelsius)
+		for _, f := range []struct {
+			name  string
+			value json.Number
+		}{
+			{"mean", r.Mean},
+			{"min", r.Min},
+			{"max", r.Max},
+		} {
+			v, err := f.value.Float64()
+			if err != nil {
+				continue
+			}
+			z := zscore(v, s)
+			if math.Abs(z) <= threshold {
+				continue
+			}
+			anomalies = append(anomalies, anomaly{
+				Kind:    anomalyStation,
+				Station: name,
+				Field:   f.name,
+				Value:   f.value,
+				ZScore:  z,
+			})
+		}
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		return anomalies[i].Station < anomalies[j].Station
+	})
+	return anomalies
+}
+
+// rowAnomalies returns the rows of the file at path with values outside of
+// their station's baseline range, ordered by offset. At most maxRows rows are
+// returned along with the total number of anomalous rows. Rows for stations
+// without a baseline are ignored.
+func rowAnomalies(path string, b *baseline, opts *Options, maxRows int) ([]anomaly, int, error) {
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	// Convert the baseline ranges to integers so rows can be compared
+	// without converting their values. Values with unit suffixes are
+	// rounded to the output scale first.
+	type bounds struct {
+		min, max int
+		base     *baselineStation
+	}
+	scale := b.outputScale()
+	div := math.Pow10(scale)
+	round := func(num int) int { return num }
+	if f := int(math.Pow10(b.Scale - scale)); f > 1 {
+		round = func(num int) int {
+			// Round half away from zero.
+			if num < 0 {
+				return -((-num + f/2) / f)
+			}
+			return (num + f/2) / f
+		}
+	}
+	ranges := make(map[string]bounds, len(b.Stations))
+	for name, s := range b.stations() {
+		ranges[name] = bounds{
+			min:  int(math.Round(s.Min * div)),
+			max:  int(math.Round(s.Max * div)),
+			base: s,
+		}
+	}
+
+	parsers := make([]*parser, runtime.GOMAXPROCS(0))
+	found := make([][]anomaly, len(parsers))
+	counts := make([]int, len(parsers))
+	for i := range parsers {
+		parsers[i] = newParser(opts)
+	}
+	err = scanDataRandom(data, segmentSize, parsers, func(i int, chunk []byte, offset int64) error {
+		return parsers[i].eachLine(chunk, offset, func(name []byte, num int, offset int64) {
+			r, ok := ranges[string(name)]
+			if !ok {
+				return
+			}
+			num = round(num)
+			if num >= r.min && num <= r.max {
+				return
+			}
+			counts[i]++
+			found[i] = append(found[i], anomaly{
+				Kind:    anomalyRow,
+				Station: string(name),
+				Value:   json.Number(strconv.FormatFloat(float64(num)/div, 'f', scale, 64)),
+				Offset:  &offset,
+				Min:     &r.base.Min,
+				Max:     &r.base.Max,
+			})
+			// Keep at most maxRows rows per parser, which may be
+			// out of order.
+			if len(found[i]) > 2*maxRows {
+				sortByOffset(found[i])
+				found[i] = found[i][:maxRows]
+			}
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var anomalies []anomaly
+	var total int
+	for i := range found {
+		anomalies = append(anomalies, found[i]...)
+		total += counts[i]
+	}
+	sortByOffset(anomalies)
+	if len(anomalies) > maxRows {
+		anomalies = anomalies[:maxRows]
+	}
+	return anomalies, total, nil
+}
+
+// sortByOffset sorts anomalies by offset.
+func sortByOffset(anomalies []anomaly) {
+	sort.Slice(anomalies, func(i, j int) bool {
+		return *anomalies[i].Offset < *anomalies[j].Offset
+	})
+}
+
+// writeAnomalies writes a report of anomalies to w in the format given by
+// opts.
+func writeAnomalies(w io.Writer, anomalies []anomaly, opts *Options) error {
+	bw := bufio.NewWriter(w)
+	switch opts.format() {
+	case formatJSON:
+		if anomalies == nil {
+			anomalies = []anomaly{}
+		}
+		b, err := json.Marshal(anomalies)
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+		bw.WriteByte('\n')
+	case formatCSV:
+		cw := csv.NewWriter(bw)
+		cw.Write([]string{"kind", "station", "field", "value", "zscore", "offset", "min", "max"})
+		for _, a := range anomalies {
+			row := []string{a.Kind, a.Station, a.Field, string(a.Value), "", "", "", ""}
+			if a.Kind == anomalyStation {
+				row[4] = strconv.FormatFloat(a.ZScore, 'f', 2, 64)
+			} else {
+				row[5] = strconv.FormatInt(*a.Offset, 10)
+				row[6] = strconv.FormatFloat(*a.Min, 'f', -1, 64)
+				row[7] = strconv.FormatFloat(*a.Max, 'f', -1, 64)
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	default:
+		for _, a := range anomalies {
+			if a.Kind == anomalyStation {
+				fmt.Fprintf(bw, "%s: %s %s is %.2f standard deviations from the baseline mean\n", a.Station, a.Field, a.Value, a.ZScore)
+				continue
+			}
+			fmt.Fprintf(bw, "offset %d: %s value %s outside baseline range %g to %g\n", *a.Offset, a.Station, a.Value, *a.Min, *a.Max)
+		}
+	}
+	return bw.Flush()
+}
+
+// baselineCmd implements the baseline command which computes the historical
+// baseline for a file.
+func baselineCmd(args []string, opts *Options, w io.Writer) error {
+	fs := flag.NewFlagSet("baseline", flag.ContinueOnError)
+	out := fs.String("out", "", "write the baseline to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("baseline: expected one input file")
+	}
+
+	b, err := computeBaseline(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return writeBaseline(w, b)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := writeBaseline(f, b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// anomaliesCmd implements the anomalies command which reports stations, and
+// optionally rows, in a file that deviate from a historical baseline.
+func anomaliesCmd(args []string, opts *Options, w io.Writer) error {
+	fs := flag.NewFlagSet("anomalies", flag.ContinueOnError)
+	baselinePath := fs.String("baseline", "", "baseline `file` written by the baseline command")
+	threshold := fs.Float64("zscore", defaultZScore, "report station results more than `n` standard deviations from the baseline mean")
+	rows := fs.Bool("rows", false, "also report rows outside of their station's baseline range")
+	maxRows := fs.Int("max-rows", defaultMaxRows, "maximum number of anomalous rows to report")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case *baselinePath == "":
+		return errors.New("anomalies: -baseline is required")
+	case fs.NArg() != 1:
+		return errors.New("anomalies: expected one input file")
+	case *threshold < 0:
+		return fmt.Errorf("anomalies: invalid -zscore: %v", *threshold)
+	case *maxRows < 0:
+		return fmt.Errorf("anomalies: invalid -max-rows: %d", *maxRows)
+	case opts.Window > 0:
+		return errors.New("anomalies: windowed results are not supported")
+	case opts.unit() != unitCelsius:
+		return errors.New("anomalies: results in units other than Celsius are not supported")
+	}
+
+	b, err := readBaselineFile(*baselinePath)
+	if err != nil {
+		return err
+	}
+	switch {
+	case b.UnitSuffix != opts.UnitSuffix:
+		return fmt.Errorf("anomalies: %w: baseline and input must both use unit suffixes or neither", errBaselineFormat)
+	case b.Scale != opts.valueScale():
+		return fmt.Errorf("anomalies: %w: baseline scale %d does not match scale %d", errBaselineFormat, b.outputScale(), opts.scale())
+	}
+
+	path := fs.Arg(0)
+	m, err := processFileRandom(path, segmentSize, opts)
+	if err != nil {
+		return err
+	}
+	anomalies := stationAnomalies(m, b, *threshold)
+	if *rows {
+		found, total, err := rowAnomalies(path, b, opts, *maxRows)
+		if err != nil {
+			return err
+		}
+		anomalies = append(anomalies, found...)
+		if total > len(found) {
+			log.Printf("%d more anomalous rows not shown", total-len(found))
+		}
+	}
+	return writeAnomalies(w, anomalies, opts)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_moments(t *testing.T) {
+	t.Parallel()
+
+	values := []int{-31, 129, 50, 122, 14, -5, 0, 77}
+
+	var all moments
+	for _, v := range values {
+		all.add(v)
+	}
+	var left, right moments
+	for _, v := range values[:3] {
+		left.add(v)
+	}
+	for _, v := range values[3:] {
+		right.add(v)
+	}
+	left.merge(&right)
+
+	var sum float64
+	for _, v := range values {
+		sum += float64(v)
+	}
+	mean := sum / float64(len(values))
+	var ss float64
+	for _, v := range values {
+		ss += (float64(v) - mean) * (float64(v) - mean)
+	}
+	stddev := math.Sqrt(ss / float64(len(values)-1))
+
+	approx := cmpopts.EquateApprox(0, 1e-9)
+	for name, s := range map[string]*moments{"add": &all, "merge": &left} {
+		if s.count != len(values) || s.min != -31 || s.max != 129 {
+			t.Fatalf("%s: unexpected count, min or max: %+v", name, s)
+		}
+		if !cmp.Equal(mean, s.mean, approx) {
+			t.Fatalf("%s: want mean %v, got %v", name, mean, s.mean)
+		}
+		if !cmp.Equal(stddev, s.stddev(), approx) {
+			t.Fatalf("%s: want stddev %v, got %v", name, stddev, s.stddev())
+		}
+	}
+}
+
+// writeBaselineFixture writes a baseline for Halifax with a mean of 12.0 and a
+// standard deviation of 2.0 and for Zagreb with no deviation.
+func writeBaselineFixture(t *testing.T, dir string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "history.txt")
+	if err := os.WriteFile(path, []byte("Halifax;10.0\nZagreb;5.0\nHalifax;12.0\nHalifax;14.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	basePath := filepath.Join(dir, "baseline.json")
+	if err := baselineCmd([]string{"-out", basePath, path}, &Options{}, nil); err != nil {
+		t.Fatalf("baselineCmd: %v", err)
+	}
+	return basePath
+}
+
+func Test_computeBaseline(t *testing.T) {
+	t.Parallel()
+
+	b, err := readBaselineFile(writeBaselineFixture(t, t.TempDir()))
+	if err != nil {
+		t.Fatalf("readBaselineFile: %v", err)
+	}
+	want := &baseline{
+		Version: baselineVersion,
+		Scale:   1,
+		Stations: []baselineStation{
+			{Name: "Halifax", Count: 3, Mean: 12, StdDev: 2, Min: 10, Max: 14},
+			{Name: "Zagreb", Count: 1, Mean: 5, Min: 5, Max: 5},
+		},
+	}
+	if diff := cmp.Diff(want, b, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+}
+
+func Test_anomaliesCmd(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	basePath := writeBaselineFixture(t, dir)
+	path := filepath.Join(dir, "new.txt")
+	data := "Halifax;11.0\nZagreb;9.0\nHalifax;30.0\nParis;50.0\nHalifax;13.0\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	testCases := map[string]struct {
+		args     []string
+		opts     *Options
+		expected string
+	}{
+		"stations": {
+			args:     []string{"-baseline", basePath, path},
+			opts:     &Options{},
+			expected: "Halifax: max 30.0 is 9.00 standard deviations from the baseline mean\n",
+		},
+		"threshold": {
+			args: []string{"-baseline", basePath, "-zscore", "1", path},
+			opts: &Options{},
+			expected: "Halifax: mean 18.0 is 3.00 standard deviations from the baseline mean\n" +
+				"Halifax: max 30.0 is 9.00 standard deviations from the baseline mean\n",
+		},
+		"rows": {
+			args: []string{"-baseline", basePath, "-rows", path},
+			opts: &Options{},
+			expected: "Halifax: max 30.0 is 9.00 standard deviations from the baseline mean\n" +
+				"offset 13: Zagreb value 9.0 outside baseline range 5 to 5\n" +
+				"offset 24: Halifax value 30.0 outside baseline range 10 to 14\n",
+		},
+		"max rows": {
+			args:     []string{"-baseline", basePath, "-rows", "-max-rows", "1", "-zscore", "100", path},
+			opts:     &Options{},
+			expected: "offset 13: Zagreb value 9.0 outside baseline range 5 to 5\n",
+		},
+		"json": {
+			args: []string{"-baseline", basePath, "-rows", "-max-rows", "1", path},
+			opts: &Options{Format: formatJSON},
+			expected: `[{"kind":"station","station":"Halifax","field":"max","value":30.0,"zscore":9},` +
+				`{"kind":"row","station":"Zagreb","value":9.0,"offset":13,"min":5,"max":5}]` + "\n",
+		},
+		"csv": {
+			args: []string{"-baseline", basePath, "-rows", "-max-rows", "1", path},
+			opts: &Options{Format: formatCSV},
+			expected: "kind,station,field,value,zscore,offset,min,max\n" +
+				"station,Halifax,max,30.0,9.00,,,\n" +
+				"row,Zagreb,,9.0,,13,5,5\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := anomaliesCmd(tc.args, tc.opts, &b); err != nil {
+				t.Fatalf("anomaliesCmd: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, b.String()); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if err := anomaliesCmd([]string{"-baseline", basePath, path}, &Options{Scale: 2}, &strings.Builder{}); !cmp.Equal(errBaselineFormat, err, cmpopts.EquateErrors()) {
+		t.Fatalf("anomaliesCmd: want %v, got %v", errBaselineFormat, err)
+	}
+}
+
+func Test_anomaliesCmd_unitSuffix(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	histPath := filepath.Join(dir, "history.txt")
+	if err := os.WriteFile(histPath, []byte("a;10.0F\na;12.0F\na;14.0F\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	basePath := filepath.Join(dir, "baseline.json")
+	opts := &Options{UnitSuffix: true}
+	if err := baselineCmd([]string{"-out", basePath, histPath}, opts, nil); err != nil {
+		t.Fatalf("baselineCmd: %v", err)
+	}
+
+	// Values with unit suffixes have extra digits that are rounded off in
+	// the baseline and the report.
+	b, err := readBaselineFile(basePath)
+	if err != nil {
+		t.Fatalf("readBaselineFile: %v", err)
+	}
+	want := &baseline{
+		Version:    baselineVersion,
+		Scale:      3,
+		UnitSuffix: true,
+		Stations: []baselineStation{
+			{Name: "a", Count: 3, Mean: -11.111, StdDev: 1.111, Min: -12.2, Max: -10},
+		},
+	}
+	if diff := cmp.Diff(want, b, cmpopts.EquateApprox(0, 1e-3)); diff != "" {
+		t.Fatalf("unexpected baseline (-want, +got):\n%s", diff)
+	}
+
+	// The row at the baseline minimum is in range once rounded.
+	path := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(path, []byte("a;-11.1\na;10.0F\na;20.0F\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	var out strings.Builder
+	if err := anomaliesCmd([]string{"-baseline", basePath, "-rows", path}, opts, &out); err != nil {
+		t.Fatalf("anomaliesCmd: %v", err)
+	}
+	expected := "a: max -6.7 is 3.97 standard deviations from the baseline mean\n" +
+		"offset 16: a value -6.7 outside baseline range -12.2 to -10\n"
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	if err := anomaliesCmd([]string{"-baseline", basePath, path}, &Options{Scale: 3}, &strings.Builder{}); !cmp.Equal(errBaselineFormat, err, cmpopts.EquateErrors()) {
+		t.Fatalf("anomaliesCmd: want %v, got %v", errBaselineFormat, err)
+	}
+}
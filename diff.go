@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Status of a station in a diff.
+const (
+	diffChanged = "changed"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+)
+
+// stationDiff is the difference between the results for a station in two
+// result sets. Old is nil if the station was added and New is nil if the
+// station was removed.
+type stationDiff struct {
+	Name     string
+	Old, New *TempInfo
+}
+
+// status returns the status of the station.
+func (d *stationDiff) status() string {
+	switch {
+	case d.Old == nil:
+		return diffAdded
+	case d.New == nil:
+		return diffRemoved
+	}
+	return diffChanged
+}
+
+// deltas returns the changes in min, mean, max and count for values in units
+// of 10^-valueScale, rounded to scale fractional digits. Each change is the
+// difference of the rounded values. Deltas are zero if the station was added
+// or removed.
+func (d *stationDiff) deltas(valueScale, scale int) (minDelta, meanDelta, maxDelta float64, countDelta int) {
+	if d.Old == nil || d.New == nil {
+		return 0, 0, 0, 0
+	}
+	div := math.Pow10(scale)
+	delta := func(o, n, oCount, nCount int) float64 {
+		return float64(fromCelsius(unitCelsius, n, nCount, valueScale, scale)-fromCelsius(unitCelsius, o, oCount, valueScale, scale)) / div
+	}
+	minDelta = delta(d.Old.Min, d.New.Min, 1, 1)
+	meanDelta = delta(d.Old.Sum, d.New.Sum, d.Old.Count, d.New.Count)
+	maxDelta = delta(d.Old.Max, d.New.Max, 1, 1)
+	return minDelta, meanDelta, maxDelta, d.New.Count - d.Old.Count
+}
+
+// diffResults returns the differences between the before and after results in
+// name order. Added and removed stations are always included. If threshold is
+// zero, stations present in both results are included if their min, mean, max
+// or count changed. Otherwise they are included if the absolute change in
+// their min, mean or max is at least threshold, and changes in count alone are
+// ignored.
+func diffResults(before, after *partial, threshold float64) ([]stationDiff, error) {
+	switch {
+	case before.Scale != after.Scale:
+		return nil, fmt.Errorf("%w: cannot compare scale %d with scale %d", errPartialFormat, before.Scale, after.Scale)
+	case before.UnitSuffix != after.UnitSuffix:
+		return nil, fmt.Errorf("%w: cannot compare results with and without unit suffixes", errPartialFormat)
+	case before.Window != after.Window:
+		return nil, fmt.Errorf("%w: cannot compare window %v with window %v", errPartialFormat, before.Window, after.Window)
+	case !slices.Equal(before.Columns, after.Columns):
+		return nil, fmt.Errorf("%w: cannot compare columns %q with columns %q", errPartialFormat, before.Columns, after.Columns)
+	case len(before.Columns) > 1:
+		return nil, errors.New("results with multiple value columns cannot be compared")
+	}
+
+	changed := func(minDelta, meanDelta, maxDelta float64, countDelta int) bool {
+		if threshold == 0 {
+			return minDelta != 0 || meanDelta != 0 || maxDelta != 0 || countDelta != 0
+		}
+		return math.Abs(minDelta) >= threshold || math.Abs(meanDelta) >= threshold || math.Abs(maxDelta) >= threshold
+	}
+
+	var diffs []stationDiff
+	for name, o := range before.Stations {
+		n, ok := after.Stations[name]
+		if !ok {
+			diffs = append(diffs, stationDiff{Name: name, Old: o})
+			continue
+		}
+		d := stationDiff{Name: name, Old: o, New: n}
+		if changed(d.deltas(before.Scale, before.outputScale())) {
+			diffs = append(diffs, d)
+		}
+	}
+	for name, n := range after.Stations {
+		if _, ok := before.Stations[name]; !ok {
+			diffs = append(diffs, stationDiff{Name: name, New: n})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs, nil
+}
+
+// statsJSON is the JSON encoding of the results for a station in a diff.
+type statsJSON struct {
+	Min   json.Number `json:"min"`
+	Mean  json.Number `json:"mean"`
+	Max   json.Number `json:"max"`
+	Count int         `json:"count"`
+}
+
+// diffJSON is the JSON encoding of a stationDiff.
+type diffJSON struct {
+	Window string     `json:"window,omitempty"`
+	Name   string     `json:"name"`
+	Status string     `json:"status"`
+	Old    *statsJSON `json:"old,omitempty"`
+	New    *statsJSON `json:"new,omitempty"`
+	Delta  *statsJSON `json:"delta,omitempty"`
+}
+
+// writeDiffs writes the differences to w in the format given by opts.
+func writeDiffs(w io.Writer, diffs []stationDiff, opts *Options) error {
+	valueScale, scale := opts.valueScale(), opts.scale()
+	formatValue := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', scale, 64)
+	}
+	stats := func(info *TempInfo) *statsJSON {
+		if info == nil {
+			return nil
+		}
+		r := newColumnResult("", info, valueScale, scale, unitCelsius)
+		return &statsJSON{Min: r.Min, Mean: r.Mean, Max: r.Max, Count: r.Count}
+	}
+
+	bw := bufio.NewWriter(w)
+	var cw *csv.Writer
+	switch opts.format() {
+	case formatCSV:
+		cw = csv.NewWriter(bw)
+		header := []string{"station", "status"}
+		if opts.Window > 0 {
+			header = append([]string{"window"}, header...)
+		}
+		for _, s := range statNames {
+			header = append(header, "old_"+s, "new_"+s, "delta_"+s)
+		}
+		cw.Write(header)
+	case formatJSON:
+		bw.WriteByte('[')
+	}
+
+	for i, d := range diffs {
+		window, name := "", d.Name
+		if opts.Window > 0 {
+			window, name = splitWindowKey(d.Name)
+		}
+		dj := diffJSON{
+			Window: window,
+			Name:   name,
+			Status: d.status(),
+			Old:    stats(d.Old),
+			New:    stats(d.New),
+		}
+		if d.Old != nil && d.New != nil {
+			dMin, dMean, dMax, dCount := d.deltas(valueScale, scale)
+			dj.Delta = &statsJSON{
+				Min:   json.Number(formatValue(dMin)),
+				Mean:  json.Number(formatValue(dMean)),
+				Max:   json.Number(formatValue(dMax)),
+				Count: dCount,
+			}
+		}
+
+		switch opts.format() {
+		case formatCSV:
+			var row []string
+			if opts.Window > 0 {
+				row = append(row, window)
+			}
+			row = append(row, name, dj.Status)
+			for _, field := range []func(s *statsJSON) string{
+				func(s *statsJSON) string { return string(s.Min) },
+				func(s *statsJSON) string { return string(s.Mean) },
+				func(s *statsJSON) string { return string(s.Max) },
+				func(s *statsJSON) string { return strconv.Itoa(s.Count) },
+			} {
+				for _, s := range []*statsJSON{dj.Old, dj.New, dj.Delta} {
+					if s == nil {
+						row = append(row, "")
+					} else {
+						row = append(row, field(s))
+					}
+				}
+			}
+			cw.Write(row)
+		case formatJSON:
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			b, _ := json.Marshal(dj)
+			bw.Write(b)
+		default:
+			if window != "" {
+				name = window + " " + name
+			}
+			switch {
+			case dj.Old == nil:
+				fmt.Fprintf(bw, "+ %s=%s/%s/%s count=%d\n", name, dj.New.Min, dj.New.Mean, dj.New.Max, dj.New.Count)
+			case dj.New == nil:
+				fmt.Fprintf(bw, "- %s=%s/%s/%s count=%d\n", name, dj.Old.Min, dj.Old.Mean, dj.Old.Max, dj.Old.Count)
+			default:
+				fmt.Fprintf(bw, "~ %s=%s/%s/%s count=%+d\n", name, signed(dj.Delta.Min), signed(dj.Delta.Mean), signed(dj.Delta.Max), dj.Delta.Count)
+			}
+		}
+	}
+
+	switch opts.format() {
+	case formatCSV:
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	case formatJSON:
+		bw.WriteString("]\n")
+	}
+	return bw.Flush()
+}
+
+// signed returns the formatted number n with a leading '+' if it is not
+// negative.
+func signed(n json.Number) string {
+	if strings.HasPrefix(string(n), "-") {
+		return string(n)
+	}
+	return "+" + string(n)
+}
+
+// isPartialFile returns true if the file at path holds a partial result rather
+// than raw measurements.
+func isPartialFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	b := make([]byte, len(partialMagic))
+	n, err := io.ReadFull(f, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	b = b[:n]
+	if string(b) == partialMagic {
+		return true, nil
+	}
+	t := bytes.TrimLeft(b, " \t\r\n")
+	return len(t) > 0 && t[0] == '{', nil
+}
+
+// loadResult returns the result for the file at path, which is either a
+// partial result or raw measurements processed with opts.
+func loadResult(path string, opts *Options) (*partial, error) {
+	ok, err := isPartialFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return readPartialFile(path)
+	}
+	m, err := processFileRandom(path, segmentSize, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return newPartial(opts, m), nil
+}
+
+// diffCmd implements the diff command which compares the results for two
+// inputs, each either raw measurements or a saved partial result, and reports
+// per-station changes.
+func diffCmd(args []string, opts *Options, w io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	threshold := fs.Float64("threshold", 0, "only report stations whose min, mean or max changed by at least `degrees`; 0 reports any change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("diff: expected two inputs")
+	}
+	if *threshold < 0 {
+		return fmt.Errorf("diff: invalid -threshold: %v", *threshold)
+	}
+	if opts.unit() != unitCelsius {
+		return errors.New("diff: results in units other than Celsius are not supported")
+	}
+
+	before, err := loadResult(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	after, err := loadResult(fs.Arg(1), opts)
+	if err != nil {
+		return err
+	}
+	diffs, err := diffResults(before, after, *threshold)
+	if err != nil {
+		return err
+	}
+
+	outOpts := *opts
+	before.options(&outOpts)
+	return writeDiffs(w, diffs, &outOpts)
+}
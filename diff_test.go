@@ -0,0 +1,211 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_diffResults(t *testing.T) {
+	t.Parallel()
+
+	before := &partial{
+		Scale: 1,
+		Stations: map[string]*TempInfo{
+			"Bergen":  {Min: 10, Max: 10, Sum: 10, Count: 1},
+			"Halifax": {Min: -31, Max: 129, Sum: 98, Count: 2},
+			"Oslo":    {Min: -20, Max: 40, Sum: 20, Count: 2},
+			"Paris":   {Min: 50, Max: 50, Sum: 50, Count: 1},
+			"Zagreb":  {Min: 10, Max: 30, Sum: 40, Count: 2},
+		},
+	}
+	after := &partial{
+		Scale: 1,
+		Stations: map[string]*TempInfo{
+			"Bergen":  {Min: 10, Max: 10, Sum: 20, Count: 2},
+			"Halifax": {Min: -31, Max: 150, Sum: 119, Count: 3},
+			"Lisbon":  {Min: 150, Max: 150, Sum: 150, Count: 1},
+			"Oslo":    {Min: -20, Max: 40, Sum: 20, Count: 2},
+			"Zagreb":  {Min: 11, Max: 30, Sum: 41, Count: 2},
+		},
+	}
+
+	testCases := map[string]struct {
+		threshold float64
+		expected  []string
+	}{
+		"all": {
+			// Oslo is unchanged and only the count changed for Bergen.
+			expected: []string{"Bergen", "Halifax", "Lisbon", "Paris", "Zagreb"},
+		},
+		"threshold": {
+			threshold: 0.5,
+			expected:  []string{"Halifax", "Lisbon", "Paris"},
+		},
+		"large threshold": {
+			threshold: 10,
+			expected:  []string{"Lisbon", "Paris"},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			diffs, err := diffResults(before, after, tc.threshold)
+			if err != nil {
+				t.Fatalf("diffResults: %v", err)
+			}
+			var got []string
+			for _, d := range diffs {
+				got = append(got, d.Name)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	other := &partial{Scale: 2, Stations: map[string]*TempInfo{}}
+	if _, err := diffResults(before, other, 0); !cmp.Equal(errPartialFormat, err, cmpopts.EquateErrors()) {
+		t.Fatalf("diffResults: want %v, got %v", errPartialFormat, err)
+	}
+	columns := &partial{Scale: 1, Columns: []string{"low", "high"}, Stations: map[string]*TempInfo{}}
+	if _, err := diffResults(columns, columns, 0); err == nil {
+		t.Fatalf("diffResults: expected error for multiple value columns")
+	}
+}
+
+func Test_writeDiffs(t *testing.T) {
+	t.Parallel()
+
+	diffs := []stationDiff{
+		{
+			Name: "Halifax",
+			Old:  &TempInfo{Min: -31, Max: 129, Sum: 98, Count: 2},
+			New:  &TempInfo{Min: -31, Max: 150, Sum: 119, Count: 3},
+		},
+		{
+			Name: "Lisbon",
+			New:  &TempInfo{Min: 150, Max: 150, Sum: 150, Count: 1},
+		},
+		{
+			Name: "Paris",
+			Old:  &TempInfo{Min: 50, Max: 50, Sum: 50, Count: 1},
+		},
+	}
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected string
+	}{
+		"text": {
+			opts: &Options{},
+			expected: "~ Halifax=+0.0/-0.9/+2.1 count=+1\n" +
+				"+ Lisbon=15.0/15.0/15.0 count=1\n" +
+				"- Paris=5.0/5.0/5.0 count=1\n",
+		},
+		"csv": {
+			opts: &Options{Format: formatCSV},
+			expected: "station,status,old_min,new_min,delta_min,old_mean,new_mean,delta_mean," +
+				"old_max,new_max,delta_max,old_count,new_count,delta_count\n" +
+				"Halifax,changed,-3.1,-3.1,0.0,4.9,4.0,-0.9,12.9,15.0,2.1,2,3,1\n" +
+				"Lisbon,added,,15.0,,,15.0,,,15.0,,,1,\n" +
+				"Paris,removed,5.0,,,5.0,,,5.0,,,1,,\n",
+		},
+		"json": {
+			opts: &Options{Format: formatJSON},
+			expected: `[{"name":"Halifax","status":"changed",` +
+				`"old":{"min":-3.1,"mean":4.9,"max":12.9,"count":2},` +
+				`"new":{"min":-3.1,"mean":4.0,"max":15.0,"count":3},` +
+				`"delta":{"min":0.0,"mean":-0.9,"max":2.1,"count":1}},` +
+				`{"name":"Lisbon","status":"added","new":{"min":15.0,"mean":15.0,"max":15.0,"count":1}},` +
+				`{"name":"Paris","status":"removed","old":{"min":5.0,"mean":5.0,"max":5.0,"count":1}}]` + "\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := writeDiffs(&b, diffs, tc.opts); err != nil {
+				t.Fatalf("writeDiffs: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, b.String()); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_diffCmd(t *testing.T) {
+	t.Parallel()
+
+	// Compare a saved partial result with a raw measurements file.
+	p := &partial{
+		Scale: 1,
+		Stations: map[string]*TempInfo{
+			"Halifax":  {Min: 129, Max: 129, Sum: 129, Count: 1},
+			"Paris":    {Min: 50, Max: 50, Sum: 50, Count: 1},
+			"Tauranga": {Min: 382, Max: 400, Sum: 782, Count: 2},
+		},
+	}
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.bin")
+	if err := writePartialFile(oldPath, p, formatBinary); err != nil {
+		t.Fatalf("writePartialFile: %v", err)
+	}
+	newPath := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(newPath, []byte("Halifax;12.9\nTauranga;38.2\nLisbon;15.0\nTauranga;41.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	var b strings.Builder
+	if err := diffCmd([]string{"-threshold", "0.1", oldPath, newPath}, &Options{}, &b); err != nil {
+		t.Fatalf("diffCmd: %v", err)
+	}
+	want := "+ Lisbon=15.0/15.0/15.0 count=1\n" +
+		"- Paris=5.0/5.0/5.0 count=1\n" +
+		"~ Tauranga=+0.0/+0.5/+1.0 count=+0\n"
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	if err := diffCmd([]string{oldPath}, &Options{}, &b); err == nil {
+		t.Fatalf("diffCmd: expected error for a single input")
+	}
+}
+
+func Test_diffCmd_unitSuffix(t *testing.T) {
+	t.Parallel()
+
+	// Values with unit suffixes have extra digits that are rounded off in
+	// the output and the deltas.
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.txt")
+	if err := os.WriteFile(oldPath, []byte("a;10.0F\nb;20.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	newPath := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(newPath, []byte("a;12.0F\nb;21.0\nc;300.1K\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	var b strings.Builder
+	if err := diffCmd([]string{oldPath, newPath}, &Options{UnitSuffix: true}, &b); err != nil {
+		t.Fatalf("diffCmd: %v", err)
+	}
+	want := "~ a=+1.1/+1.1/+1.1 count=+0\n" +
+		"~ b=+1.0/+1.0/+1.0 count=+0\n" +
+		"+ c=27.0/27.0/27.0 count=1\n"
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+}
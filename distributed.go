@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRangeSize is the default size of the byte ranges handed to
+	// workers.
+	defaultRangeSize = 256 * 1024 * 1024 // 256mb
+
+	// defaultRangeTimeout is the default time a worker has to process a
+	// range before it is considered to have failed.
+	defaultRangeTimeout = 10 * time.Minute
+)
+
+var (
+	errNoWorkers      = errors.New("no workers available")
+	errPathNotAllowed = errors.New("path not allowed")
+)
+
+// RangeArgs are the arguments to the Worker.Process RPC.
+type RangeArgs struct {
+	// Path is the path of the input file on the worker.
+	Path string
+
+	// Offset and Size are the byte range of the file to process. Lines are
+	// processed if they start within the range.
+	Offset int64
+	Size   int64
+
+	Options Options
+}
+
+// RangeReply is the reply to the Worker.Process RPC.
+type RangeReply struct {
+	// Partial is the binary encoded partial result for the range.
+	Partial []byte
+
+	// Missing holds the names of stations in the range that are missing
+	// from the metadata.
+	Missing []string
+}
+
+// Worker processes byte ranges of files for a coordinator.
+type Worker struct {
+	// root, if not empty, is the absolute path of the directory, with
+	// symbolic links resolved, that files must be within. Relative paths
+	// are relative to root.
+	root string
+}
+
+// newWorker returns a new Worker that only processes files within the
+// directory root.
+func newWorker(root string) (*Worker, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	return &Worker{root: root}, nil
+}
+
+// resolve returns the path of the file at path after checking that it is
+// within the worker's root directory.
+func (w *Worker) resolve(path string) (string, error) {
+	if w.root == "" {
+		return path, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(w.root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(w.root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q is not within %q", errPathNotAllowed, path, w.root)
+	}
+	return resolved, nil
+}
+
+// Process processes the lines starting within a byte range of a file.
+func (w *Worker) Process(args *RangeArgs, reply *RangeReply) error {
+	opts := args.Options
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	path, err := w.resolve(args.Path)
+	if err != nil {
+		return err
+	}
+	m, err := processRange(path, args.Offset, args.Size, &opts)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	p := newPartial(&opts, m)
+	if err := writePartial(&b, p, formatBinary); err != nil {
+		return err
+	}
+	reply.Partial = b.Bytes()
+	reply.Missing = missingMetadata(nil, &opts)
+	return nil
+}
+
+// processRange processes the lines starting within the given byte range of the
+// file at path.
+func processRange(path string, offset, size int64, opts *Options) (map[string]*TempInfo, error) {
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	start, end, ok, err := alignSegment(data, offset, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return make(map[string]*TempInfo), nil
+	}
+	return processDataRandom(data[start:end], start, segmentSize, opts)
+}
+
+// serveWorker serves the Worker RPC service on l until l is closed.
+func serveWorker(l net.Listener, w *Worker) {
+	s := rpc.NewServer()
+	if err := s.Register(w); err != nil {
+		panic(err)
+	}
+	s.Accept(l)
+}
+
+// fileRange is a byte range of a file.
+type fileRange struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// splitFiles splits the files at the given paths into byte ranges of at most
+// size bytes.
+func splitFiles(paths []string, size int64) ([]fileRange, error) {
+	var ranges []fileRange
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		for offset := int64(0); offset < fi.Size(); offset += size {
+			ranges = append(ranges, fileRange{
+				path:   path,
+				offset: offset,
+				size:   size,
+			})
+		}
+	}
+	return ranges, nil
+}
+
+// coordinator hands out byte ranges to workers and merges their results.
+type coordinator struct {
+	workers []string
+	opts    *Options
+
+	// timeout is the maximum time to wait for a worker to process a range.
+	timeout time.Duration
+
+	// logf logs worker failures.
+	logf func(format string, args ...any)
+}
+
+// rangeResult is the result of processing a range on a worker.
+type rangeResult struct {
+	p       *partial
+	missing []string
+	err     error
+
+	// failed is true if the worker failed and will not process more ranges.
+	failed bool
+}
+
+// run processes the given ranges on the workers and returns the merged result.
+// Ranges assigned to workers that fail are reassigned to the remaining
+// workers.
+func (c *coordinator) run(ranges []fileRange) (*partial, error) {
+	result := newPartial(c.opts, nil)
+	if len(ranges) == 0 {
+		return result, nil
+	}
+
+	queue := make(chan fileRange, len(ranges))
+	for _, r := range ranges {
+		queue <- r
+	}
+	results := make(chan rangeResult)
+	done := make(chan struct{})
+	defer close(done)
+
+	for _, addr := range c.workers {
+		go c.work(addr, queue, results, done)
+	}
+
+	live := len(c.workers)
+	for remaining := len(ranges); remaining > 0; {
+		if live == 0 {
+			return nil, fmt.Errorf("%w: %d ranges not processed", errNoWorkers, remaining)
+		}
+		r := <-results
+		switch {
+		case r.failed:
+			live--
+		case r.err != nil:
+			return nil, r.err
+		default:
+			if err := result.merge(r.p); err != nil {
+				return nil, err
+			}
+			for _, name := range r.missing {
+				c.opts.Metadata.addMissing(name)
+			}
+			remaining--
+		}
+	}
+	return result, nil
+}
+
+// work processes ranges from queue on the worker at addr and sends the results
+// to results. If the worker fails the range is returned to the queue.
+func (c *coordinator) work(addr string, queue chan fileRange, results chan<- rangeResult, done <-chan struct{}) {
+	send := func(r rangeResult) bool {
+		select {
+		case results <- r:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	fail := func(err error) {
+		c.logf("worker %s failed: %v", addr, err)
+		send(rangeResult{failed: true})
+	}
+
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer client.Close()
+
+	for {
+		var fr fileRange
+		select {
+		case fr = <-queue:
+		case <-done:
+			return
+		}
+
+		args := &RangeArgs{
+			Path:    fr.path,
+			Offset:  fr.offset,
+			Size:    fr.size,
+			Options: *c.opts,
+		}
+		var reply RangeReply
+		call := client.Go("Worker.Process", args, &reply, make(chan *rpc.Call, 1))
+		timer := time.NewTimer(c.timeout)
+		// The call is owned by the client until it is done so errors
+		// are not stored in it.
+		var err error
+		select {
+		case <-call.Done:
+			timer.Stop()
+			err = call.Error
+		case <-timer.C:
+			err = fmt.Errorf("timed out after %v", c.timeout)
+		case <-done:
+			timer.Stop()
+			return
+		}
+
+		var serverErr rpc.ServerError
+		switch {
+		case errors.As(err, &serverErr):
+			// The worker processed the range but returned an error.
+			send(rangeResult{err: fmt.Errorf("%s: %s", fr.path, serverErr)})
+			return
+		case err != nil:
+			queue <- fr
+			fail(err)
+			return
+		}
+
+		p, err := readPartial(bytes.NewReader(reply.Partial))
+		if err != nil {
+			queue <- fr
+			fail(err)
+			return
+		}
+		if !send(rangeResult{p: p, missing: reply.Missing}) {
+			return
+		}
+	}
+}
+
+// workerCmd implements the worker command which serves requests to process
+// byte ranges of files from a coordinator.
+func workerCmd(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:7070", "listen on `address`")
+	root := fs.String("root", ".", "only process files within `directory`; relative paths are relative to it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := newWorker(*root)
+	if err != nil {
+		return fmt.Errorf("invalid -root: %w", err)
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("worker listening on %s serving files in %s", l.Addr(), w.root)
+	serveWorker(l, w)
+	return nil
+}
+
+// coordinatorCmd implements the coordinator command which processes the given
+// files on a set of workers and returns the merged result.
+func coordinatorCmd(args []string, opts *Options) (*partial, error) {
+	fs := flag.NewFlagSet("coordinator", flag.ContinueOnError)
+	workers := fs.String("workers", "", "comma separated list of worker `addresses`")
+	rangeSize := fs.String("range-size", "", "size of byte ranges sent to workers (default 256M)")
+	timeout := fs.Duration("timeout", defaultRangeTimeout, "maximum time for a worker to process a range")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *workers == "" {
+		return nil, fmt.Errorf("coordinator: %w", errNoWorkers)
+	}
+	if fs.NArg() == 0 {
+		return nil, errors.New("coordinator: no input files")
+	}
+	size, err := parseSize(*rangeSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -range-size: %w", err)
+	}
+	if size == 0 {
+		size = defaultRangeSize
+	}
+
+	ranges, err := splitFiles(fs.Args(), size)
+	if err != nil {
+		return nil, err
+	}
+	c := &coordinator{
+		workers: strings.Split(*workers, ","),
+		opts:    opts,
+		timeout: *timeout,
+		logf:    log.Printf,
+	}
+	p, err := c.run(ranges)
+	if err != nil {
+		return nil, err
+	}
+	return p, checkStationCount(p.Stations, opts)
+}
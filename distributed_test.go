@@ -0,0 +1,286 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// startWorker starts a worker for files in the current directory listening on
+// localhost and returns its address.
+func startWorker(t *testing.T) string {
+	t.Helper()
+	return startWorkerRoot(t, ".")
+}
+
+// startWorkerRoot starts a worker for files in root listening on localhost and
+// returns its address.
+func startWorkerRoot(t *testing.T, root string) string {
+	t.Helper()
+
+	w, err := newWorker(root)
+	if err != nil {
+		t.Fatalf("newWorker: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serveWorker(l, w)
+	return l.Addr().String()
+}
+
+// startFakeWorker starts a listener on localhost that passes accepted
+// connections to handle and returns its address.
+func startFakeWorker(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// deadAddr returns the address of a closed listener.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func Test_processRange(t *testing.T) {
+	t.Parallel()
+
+	path := "test/measurements-10000-unique-keys.txt"
+	want, err := processFileRandom(path, segmentSize, nil)
+	if err != nil {
+		t.Fatalf("processFileRandom: %v", err)
+	}
+
+	for _, size := range []int64{1000, 4096, 1 << 20} {
+		ranges, err := splitFiles([]string{path}, size)
+		if err != nil {
+			t.Fatalf("splitFiles: %v", err)
+		}
+		got := make(map[string]*TempInfo)
+		for _, r := range ranges {
+			m, err := processRange(r.path, r.offset, r.size, &Options{})
+			if err != nil {
+				t.Fatalf("processRange: %v", err)
+			}
+			mergeMap(got, m)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("size %d: unexpected result (-want, +got):\n%s", size, diff)
+		}
+	}
+}
+
+func Test_coordinator(t *testing.T) {
+	t.Parallel()
+
+	paths := []string{
+		"test/measurements-10000-unique-keys.txt",
+		"test/measurements-rounding.txt",
+		"test/measurements-complex-utf8.txt",
+	}
+	want := make(map[string]*TempInfo)
+	for _, path := range paths {
+		m, err := processFileRandom(path, segmentSize, nil)
+		if err != nil {
+			t.Fatalf("processFileRandom: %v", err)
+		}
+		mergeMap(want, m)
+	}
+
+	testCases := map[string]struct {
+		workers func(t *testing.T) []string
+		err     error
+	}{
+		"single worker": {
+			workers: func(t *testing.T) []string {
+				return []string{startWorker(t)}
+			},
+		},
+		"multiple workers": {
+			workers: func(t *testing.T) []string {
+				return []string{startWorker(t), startWorker(t), startWorker(t)}
+			},
+		},
+		"unreachable worker": {
+			workers: func(t *testing.T) []string {
+				return []string{deadAddr(t), startWorker(t)}
+			},
+		},
+		"crashing worker": {
+			workers: func(t *testing.T) []string {
+				crash := startFakeWorker(t, func(conn net.Conn) {
+					// Read part of the request and close the
+					// connection.
+					conn.Read(make([]byte, 16))
+					conn.Close()
+				})
+				return []string{crash, startWorker(t), crash}
+			},
+		},
+		"hung worker": {
+			workers: func(t *testing.T) []string {
+				hung := startFakeWorker(t, func(conn net.Conn) {
+					// Never reply.
+					buf := make([]byte, 1024)
+					for {
+						if _, err := conn.Read(buf); err != nil {
+							return
+						}
+					}
+				})
+				return []string{hung, startWorker(t)}
+			},
+		},
+		"no workers": {
+			workers: func(t *testing.T) []string {
+				return []string{deadAddr(t), deadAddr(t)}
+			},
+			err: errNoWorkers,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ranges, err := splitFiles(paths, 8192)
+			if err != nil {
+				t.Fatalf("splitFiles: %v", err)
+			}
+			c := &coordinator{
+				workers: tc.workers(t),
+				opts:    &Options{},
+				timeout: time.Second,
+				logf:    t.Logf,
+			}
+			p, err := c.run(ranges)
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(&partial{Scale: 1, Stations: want}, p); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_coordinator_parseError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	f, err := os.CreateTemp(dir, "")
+	if err != nil {
+		t.Fatalf("unable to create temporary file: %v", err)
+	}
+	if _, err := f.WriteString("foo;1.0\nbar;+2.0\n"); err != nil {
+		t.Fatalf("unable to write temporary file: %v", err)
+	}
+	f.Close()
+
+	ranges, err := splitFiles([]string{f.Name()}, 8)
+	if err != nil {
+		t.Fatalf("splitFiles: %v", err)
+	}
+	c := &coordinator{
+		workers: []string{startWorkerRoot(t, dir), startWorkerRoot(t, dir)},
+		opts:    &Options{},
+		timeout: time.Second,
+		logf:    t.Logf,
+	}
+	if _, err := c.run(ranges); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func Test_Worker_resolve(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "data")
+	if err := os.Mkdir(root, 0o700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	for _, path := range []string{filepath.Join(root, "measurements.txt"), filepath.Join(dir, "secret.txt")} {
+		if err := os.WriteFile(path, []byte("Halifax;1.0\n"), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+
+	w, err := newWorker(root)
+	if err != nil {
+		t.Fatalf("newWorker: %v", err)
+	}
+
+	testCases := map[string]struct {
+		path string
+		err  error
+	}{
+		"relative": {
+			path: "measurements.txt",
+		},
+		"absolute": {
+			path: filepath.Join(root, "measurements.txt"),
+		},
+		"parent": {
+			path: "../secret.txt",
+			err:  errPathNotAllowed,
+		},
+		"absolute outside root": {
+			path: filepath.Join(dir, "secret.txt"),
+			err:  errPathNotAllowed,
+		},
+		"symlink outside root": {
+			path: "link.txt",
+			err:  errPathNotAllowed,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var reply RangeReply
+			err := w.Process(&RangeArgs{Path: tc.path, Size: 1 << 20}, &reply)
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
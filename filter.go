@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// stationFilter selects stations by name. A station is selected if it matches
+// all of the configured filters.
+type stationFilter struct {
+	// names, if not nil, is the set of selected station names.
+	names map[string]struct{}
+
+	// prefix is the prefix of selected station names.
+	prefix string
+
+	// re, if not nil, must match selected station names.
+	re *regexp.Regexp
+}
+
+// newStationFilter returns the station filter for opts or nil if all stations
+// are selected.
+func newStationFilter(opts *Options) (*stationFilter, error) {
+	if opts.Stations == nil && opts.Prefix == "" && opts.Match == "" {
+		return nil, nil
+	}
+
+	f := &stationFilter{
+		prefix: opts.Prefix,
+	}
+	if opts.Stations != nil {
+		// Names are matched after they are normalized so normalize
+		// the selected names the same way.
+		normalize := newNormalizer(opts)
+		f.names = make(map[string]struct{}, len(opts.Stations))
+		for _, name := range opts.Stations {
+			if normalize != nil {
+				name = string(normalize([]byte(name)))
+			}
+			f.names[name] = struct{}{}
+		}
+	}
+	if opts.Match != "" {
+		re, err := regexp.Compile(opts.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid station match: %w", err)
+		}
+		f.re = re
+	}
+	return f, nil
+}
+
+// match returns true if the station name is selected.
+func (f *stationFilter) match(name []byte) bool {
+	if f.names != nil {
+		if _, ok := f.names[string(name)]; !ok {
+			return false
+		}
+	}
+	if !bytes.HasPrefix(name, []byte(f.prefix)) {
+		return false
+	}
+	return f.re == nil || f.re.Match(name)
+}
+
+// stationKey is the cached result of filtering and rewriting a station name.
+type stationKey struct {
+	// keep is true if the station is selected by the filter.
+	keep bool
+
+	// name is the rewritten key for the station or nil if the name is not
+	// rewritten.
+	name []byte
+}
+
+// lookupKey returns the filter and rewrite result for the station name.
+// Names are normalized before they are filtered and rewritten. Results are
+// cached so that each unique name is only normalized, matched and rewritten
+// once, unless the cache is cleared to stay within the memory budget.
+// Selected stations without metadata are recorded as missing.
+func (p *parser) lookupKey(name []byte) stationKey {
+	if k, ok := p.keyCache[string(name)]; ok {
+		return k
+	}
+	key := name
+	if p.normalize != nil {
+		key = p.normalize(name)
+	}
+	k := stationKey{keep: p.filter == nil || p.filter.match(key)}
+	canonical := string(key)
+	if k.keep && p.rewriter != nil {
+		canonical = p.rewriter.canonical(canonical)
+		var r string
+		r, k.keep = p.rewriter.rewrite(string(key))
+		if r != string(key) {
+			key = []byte(r)
+		}
+	}
+	if k.keep && p.opts.Metadata != nil {
+		p.opts.Metadata.addMissing(canonical)
+	}
+	if k.keep && !bytes.Equal(key, name) {
+		k.name = key
+	}
+	if p.keyCacheBudget > 0 {
+		size := int64(len(name)+len(k.name)) + entryOverhead
+		if p.keyCacheSize+size > p.keyCacheBudget {
+			// Start a new map as clearing a map does not free its
+			// memory.
+			p.keyCache = make(map[string]stationKey)
+			p.keyCacheSize = 0
+		}
+		p.keyCacheSize += size
+	}
+	p.keyCache[string(name)] = k
+	return k
+}
+
+// readStations reads a list of station names from the file at path. Names are
+// given one per line. Empty lines are ignored.
+func readStations(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := []string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\r"))
+		if len(line) > 0 {
+			names = append(names, string(line))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return names, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_stationFilter(t *testing.T) {
+	t.Parallel()
+
+	names := []string{"Adelaide", "Cabo San Lucas", "Halifax", "Tauranga", "Zagreb"}
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected []string
+	}{
+		"stations": {
+			opts:     &Options{Stations: []string{"Halifax", "Zagreb", "Paris"}},
+			expected: []string{"Halifax", "Zagreb"},
+		},
+		"no stations": {
+			opts: &Options{Stations: []string{}},
+		},
+		"prefix": {
+			opts:     &Options{Prefix: "Ca"},
+			expected: []string{"Cabo San Lucas"},
+		},
+		"match": {
+			opts:     &Options{Match: "a$"},
+			expected: []string{"Tauranga"},
+		},
+		"all": {
+			opts:     &Options{Stations: []string{"Halifax", "Tauranga", "Zagreb"}, Prefix: "Ta", Match: "ng"},
+			expected: []string{"Tauranga"},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := newStationFilter(tc.opts)
+			if err != nil {
+				t.Fatalf("newStationFilter: %v", err)
+			}
+			var got []string
+			for _, n := range names {
+				if f.match([]byte(n)) {
+					got = append(got, n)
+				}
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if f, err := newStationFilter(&Options{}); f != nil || err != nil {
+		t.Fatalf("newStationFilter: want nil, got %v, %v", f, err)
+	}
+	if _, err := newStationFilter(&Options{Match: "("}); err == nil {
+		t.Fatalf("newStationFilter: expected error for invalid regular expression")
+	}
+}
+
+func Test_processFile_filter(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path     string
+		opts     *Options
+		expected map[string]*TempInfo
+		err      error
+	}{
+		"fast path": {
+			path: "test/measurements-10.txt",
+			opts: &Options{Stations: []string{"Halifax", "Zagreb", "Paris"}},
+			expected: map[string]*TempInfo{
+				"Halifax": {Min: 129, Max: 129, Sum: 129, Count: 1},
+				"Zagreb":  {Min: 122, Max: 122, Sum: 122, Count: 1},
+			},
+		},
+		"default path": {
+			path: "test/options/measurements-crlf.txt",
+			opts: &Options{CRLF: true, SkipBOM: true, Prefix: "Ha"},
+			expected: map[string]*TempInfo{
+				"Halifax": {Min: -31, Max: 129, Sum: 98, Count: 2},
+			},
+		},
+		"windowed": {
+			path: "test/options/measurements-timestamps.txt",
+			opts: &Options{Window: time.Hour, Match: "^Z"},
+			expected: map[string]*TempInfo{
+				"2026-10-17T12:00:00ZZagreb": {Min: 14, Max: 122, Sum: 136, Count: 2},
+				"2026-10-18T00:00:00ZZagreb": {Min: -5, Max: -5, Sum: -5, Count: 1},
+			},
+		},
+		"normalized stations": {
+			// Selected names are normalized like station names.
+			path: "test/options/measurements-unicode.txt",
+			opts: &Options{Normalize: normalizeNFKC, FoldCase: true, Stations: []string{"Se\u0301gou", "FIJI"}},
+			expected: map[string]*TempInfo{
+				"ségou": {Min: 100, Max: 257, Sum: 558, Count: 3},
+				"fiji":  {Min: 50, Max: 70, Sum: 120, Count: 2},
+			},
+		},
+		"no match": {
+			path:     "test/measurements-10.txt",
+			opts:     &Options{Prefix: "Paris"},
+			expected: map[string]*TempInfo{},
+		},
+		"invalid values": {
+			path: "test/options/measurements-invalid.txt",
+			opts: &Options{Prefix: "Paris"},
+			err:  errInputFormat,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, size := range []int{5, 16, chunkSize} {
+				f, err := os.Open(tc.path)
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				defer f.Close()
+
+				m, err := processFile(f, size, tc.opts)
+				if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+					t.Fatalf("processFile(%d): unexpected error (-want, +got):\n%s", size, diff)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFile(%d): unexpected result (-want, +got):\n%s", size, diff)
+				}
+
+				m, err = processFileRandom(tc.path, size, tc.opts)
+				if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+					t.Fatalf("processFileRandom(%d): unexpected error (-want, +got):\n%s", size, diff)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFileRandom(%d): unexpected result (-want, +got):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_parser_processChunk_filter(b *testing.B) {
+	c, err := os.ReadFile("test/measurements-10000-unique-keys.txt")
+	if err != nil {
+		b.Fatalf("ReadFile: %v", err)
+	}
+
+	for name, opts := range map[string]*Options{
+		"unfiltered": {},
+		"filtered":   {Prefix: "id1"},
+	} {
+		b.Run(name, func(b *testing.B) {
+			p := newParser(opts)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = p.processChunk(c, 0)
+			}
+		})
+	}
+}
+
+func Test_readStations(t *testing.T) {
+	t.Parallel()
+
+	got, err := readStations("test/options/stations.txt")
+	if err != nil {
+		t.Fatalf("readStations: %v", err)
+	}
+	if diff := cmp.Diff([]string{"Halifax", "Zagreb", "Tauranga"}, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	if _, err := readStations("test/options/missing.txt"); err == nil {
+		t.Fatalf("readStations: expected error for missing file")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// followChunkSize is the chunk size used when following a file.
+	followChunkSize = 4 * 1024 * 1024 // 4mb
+
+	// defaultInterval is the default interval between results in follow
+	// mode.
+	defaultInterval = time.Second
+)
+
+// follower aggregates the lines appended to a file as it grows. Lines are read
+// through the chunk pipeline and a partial line at the end of the file is kept
+// until it is completed. If the file is truncated or replaced, e.g. by log
+// rotation, reading continues from the start of the file at path and results
+// are accumulated across files.
+type follower struct {
+	path string
+	opts *Options
+
+	f     *os.File
+	inode uint64
+	cr    *chunkReader
+
+	m map[string]*TempInfo
+}
+
+// newFollower opens the file at path and returns a follower for it.
+func newFollower(path string, opts *Options) (*follower, error) {
+	fl := &follower{
+		path: path,
+		opts: opts,
+		m:    make(map[string]*TempInfo, maxCities),
+	}
+	if err := fl.open(); err != nil {
+		return nil, err
+	}
+	return fl, nil
+}
+
+// open opens the file at path and resets reading to the start of the file.
+func (fl *follower) open() error {
+	f, err := os.Open(fl.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fl.f != nil {
+		fl.f.Close()
+	}
+	fl.f = f
+	fl.inode = fileInode(fi)
+	if fl.cr == nil {
+		fl.cr = newChunkReader(followChunkSize, newParser(fl.opts))
+	} else {
+		fl.cr.reset()
+	}
+	return nil
+}
+
+// consumed returns the number of bytes read from the current file.
+func (fl *follower) consumed() int64 {
+	return fl.cr.offset + int64(len(fl.cr.remainder))
+}
+
+// poll processes the complete lines appended to the file since the last call
+// to poll.
+func (fl *follower) poll() error {
+	fi, err := fl.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < fl.consumed() {
+		// The file was truncated in place.
+		if _, err := fl.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		fl.cr.reset()
+	}
+	if err := fl.read(fi.Size() > fl.consumed(), false); err != nil {
+		return err
+	}
+
+	// Check whether the file at path was replaced.
+	pfi, err := os.Stat(fl.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// The file was moved and not yet recreated.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fileInode(pfi) == fl.inode {
+		return nil
+	}
+
+	// Read anything appended to the old file before it was replaced, including
+	// its last line, and start reading the new file.
+	if err := fl.read(true, true); err != nil {
+		return err
+	}
+	if err := fl.open(); err != nil {
+		return err
+	}
+	return fl.read(true, false)
+}
+
+// read reads the current file until io.EOF if more is true and merges the
+// results. If flush is true the partial line at the end of the file is
+// processed as the last line.
+func (fl *follower) read(more, flush bool) error {
+	if !more && !flush {
+		return nil
+	}
+	err := aggregateChunks(fl.opts, func(chunkChan chan inputChunk, errChan chan error) {
+		defer close(chunkChan)
+		if more {
+			if err := fl.cr.read(fl.f, chunkChan); err != nil {
+				errChan <- err
+				return
+			}
+		}
+		if flush {
+			fl.cr.flush(chunkChan)
+		}
+	}, func(m map[string]*TempInfo) error {
+		mergeMap(fl.m, m)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fl.path, err)
+	}
+	return checkStationCount(fl.m, fl.opts)
+}
+
+// Close closes the file.
+func (fl *follower) Close() error {
+	return fl.f.Close()
+}
+
+// follow polls the file every interval and calls emit with the updated results
+// until ctx is done.
+func (fl *follower) follow(ctx context.Context, interval time.Duration, emit func(map[string]*TempInfo) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := fl.poll(); err != nil {
+			return err
+		}
+		if err := emit(fl.m); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_follower_poll(t *testing.T) {
+	t.Parallel()
+
+	// step changes the file before a poll.
+	type step struct {
+		// appendData is appended to the file.
+		appendData string
+
+		// truncate truncates the file before appending.
+		truncate bool
+
+		// replace replaces the file with a new file before appending.
+		replace bool
+
+		// processed is all of the input that should have been processed
+		// after the poll.
+		processed string
+	}
+
+	testCases := map[string]struct {
+		initial string
+		steps   []step
+	}{
+		"append": {
+			initial: "foo;1.0\n",
+			steps: []step{
+				{processed: "foo;1.0\n"},
+				{appendData: "bar;2.0\nfoo;3.0\n", processed: "foo;1.0\nbar;2.0\nfoo;3.0\n"},
+				{processed: "foo;1.0\nbar;2.0\nfoo;3.0\n"},
+			},
+		},
+		"partial line": {
+			initial: "foo;1.0\nbar;2",
+			steps: []step{
+				{processed: "foo;1.0\n"},
+				{appendData: ".5", processed: "foo;1.0\n"},
+				{appendData: "\nbaz;", processed: "foo;1.0\nbar;2.5\n"},
+				{appendData: "-1.0\n", processed: "foo;1.0\nbar;2.5\nbaz;-1.0\n"},
+			},
+		},
+		"truncated": {
+			initial: "foo;1.0\nbar;2.0\n",
+			steps: []step{
+				{processed: "foo;1.0\nbar;2.0\n"},
+				{truncate: true, appendData: "baz;3.0\n", processed: "foo;1.0\nbar;2.0\nbaz;3.0\n"},
+				{appendData: "foo;4.0\n", processed: "foo;1.0\nbar;2.0\nbaz;3.0\nfoo;4.0\n"},
+			},
+		},
+		"rotated": {
+			initial: "foo;1.0\nbar;2",
+			steps: []step{
+				{processed: "foo;1.0\n"},
+				{appendData: ".0", replace: true, processed: "foo;1.0\nbar;2.0\n"},
+				{appendData: "baz;3.0\n", processed: "foo;1.0\nbar;2.0\nbaz;3.0\n"},
+				{replace: true, appendData: "foo;4.0\n", processed: "foo;1.0\nbar;2.0\nbaz;3.0\nfoo;4.0\n"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "measurements.txt")
+			if err := os.WriteFile(path, []byte(tc.initial), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			fl, err := newFollower(path, &Options{})
+			if err != nil {
+				t.Fatalf("newFollower: %v", err)
+			}
+			defer fl.Close()
+
+			for i, s := range tc.steps {
+				if s.replace {
+					// Append to the old file and replace it
+					// with an empty file.
+					appendFile(t, path, s.appendData)
+					if err := os.Rename(path, path+".1"); err != nil {
+						t.Fatalf("Rename: %v", err)
+					}
+					if err := os.WriteFile(path, nil, 0o600); err != nil {
+						t.Fatalf("WriteFile: %v", err)
+					}
+				} else {
+					if s.truncate {
+						if err := os.Truncate(path, 0); err != nil {
+							t.Fatalf("Truncate: %v", err)
+						}
+					}
+					appendFile(t, path, s.appendData)
+				}
+
+				if err := fl.poll(); err != nil {
+					t.Fatalf("step %d: poll: %v", i, err)
+				}
+				want, err := processFile(bytes.NewReader([]byte(s.processed)), chunkSize, nil)
+				if err != nil {
+					t.Fatalf("step %d: processFile: %v", i, err)
+				}
+				if diff := cmp.Diff(want, fl.m); diff != "" {
+					t.Fatalf("step %d: unexpected result (-want, +got):\n%s", i, diff)
+				}
+			}
+		})
+	}
+}
+
+// appendFile appends data to the file at path.
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+}
+
+func Test_follower_reuseBuffers(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte("foo;1.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fl, err := newFollower(path, &Options{})
+	if err != nil {
+		t.Fatalf("newFollower: %v", err)
+	}
+	defer fl.Close()
+	if err := fl.poll(); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	// seen holds the read buffers used so far, identified by their first
+	// byte. Reading the file needs at most two buffers at a time.
+	seen := make(map[*byte]bool)
+	addFree := func() {
+		var free [][]byte
+		for len(fl.cr.free) > 0 {
+			free = append(free, <-fl.cr.free)
+		}
+		for _, buf := range free {
+			seen[&buf[0]] = true
+			fl.cr.free <- buf
+		}
+	}
+	addFree()
+
+	for i, data := range []string{"bar;2.0\n", "baz;3.0\n", "foo;4.0\n"} {
+		if i == 1 {
+			// Truncating the file restarts reading with the same buffers.
+			if err := os.Truncate(path, 0); err != nil {
+				t.Fatalf("Truncate: %v", err)
+			}
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatalf("WriteString: %v", err)
+		}
+		f.Close()
+		if err := fl.poll(); err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		addFree()
+	}
+	if len(seen) == 0 || len(seen) > 2 {
+		t.Fatalf("want 1 or 2 read buffers, got %d", len(seen))
+	}
+}
+
+func Test_follower_follow(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte("foo;1.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fl, err := newFollower(path, &Options{})
+	if err != nil {
+		t.Fatalf("newFollower: %v", err)
+	}
+	defer fl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var counts []int
+	err = fl.follow(ctx, time.Millisecond, func(m map[string]*TempInfo) error {
+		counts = append(counts, m["foo"].Count)
+		switch len(counts) {
+		case 1:
+			appendFile(t, path, "foo;2.0\n")
+		case 2:
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 2}, counts); diff != "" {
+		t.Fatalf("unexpected counts (-want, +got):\n%s", diff)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/ianlewis/1brc-go
 
 go 1.21.2
 
-require github.com/google/go-cmp v0.6.0
+require (
+	github.com/google/go-cmp v0.6.0
+	golang.org/x/text v0.14.0
+)
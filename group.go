@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// keyRewriter rewrites station names to the keys their results are
+// aggregated under. Names are first replaced by their alias and then by their
+// group.
+type keyRewriter struct {
+	// aliases maps station names to canonical names.
+	aliases map[string]string
+
+	// groups maps canonical names to group names.
+	groups map[string]string
+
+	// re, if not nil, groups canonical names by the text matched by its
+	// first capturing group.
+	re *regexp.Regexp
+
+	// metadata and where, if not nil, select stations by the values of
+	// their metadata columns. where maps column indexes to values.
+	metadata *Metadata
+	where    map[int]string
+}
+
+// newKeyRewriter returns the key rewriter for opts or nil if station names are
+// not rewritten.
+func newKeyRewriter(opts *Options) (*keyRewriter, error) {
+	if opts.Aliases == nil && !opts.grouped() && opts.Where == nil {
+		return nil, nil
+	}
+	groupings := 0
+	for _, ok := range []bool{opts.Groups != nil, opts.GroupMatch != "", opts.GroupBy != ""} {
+		if ok {
+			groupings++
+		}
+	}
+	if groupings > 1 {
+		return nil, errors.New("only one of groups, group match or group by can be used")
+	}
+	if opts.Metadata == nil && (opts.GroupBy != "" || opts.Where != nil) {
+		return nil, errors.New("group by and where require metadata")
+	}
+
+	r := &keyRewriter{
+		aliases: opts.Aliases,
+		groups:  opts.Groups,
+	}
+	if opts.GroupMatch != "" {
+		re, err := regexp.Compile(opts.GroupMatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group match: %w", err)
+		}
+		r.re = re
+	}
+	if opts.GroupBy != "" {
+		col := opts.Metadata.column(opts.GroupBy)
+		if col < 0 {
+			return nil, fmt.Errorf("unknown metadata column %q", opts.GroupBy)
+		}
+		r.groups = make(map[string]string, len(opts.Metadata.Stations))
+		for name, values := range opts.Metadata.Stations {
+			r.groups[name] = values[col]
+		}
+	}
+	if opts.Where != nil {
+		r.metadata = opts.Metadata
+		r.where = make(map[int]string, len(opts.Where))
+		for name, value := range opts.Where {
+			col := opts.Metadata.column(name)
+			if col < 0 {
+				return nil, fmt.Errorf("unknown metadata column %q", name)
+			}
+			r.where[col] = value
+		}
+	}
+	return r, nil
+}
+
+// canonical returns the canonical name for the station name.
+func (r *keyRewriter) canonical(name string) string {
+	if alias, found := r.aliases[name]; found {
+		return alias
+	}
+	return name
+}
+
+// rewrite returns the key for the station name. keep is false if the station
+// is not selected by its metadata.
+func (r *keyRewriter) rewrite(name string) (key string, keep bool) {
+	key = r.canonical(name)
+	if r.where != nil {
+		values, ok := r.metadata.Stations[key]
+		if !ok {
+			return key, false
+		}
+		for col, value := range r.where {
+			if values[col] != value {
+				return key, false
+			}
+		}
+	}
+	if group, found := r.groups[key]; found {
+		key = group
+	}
+	if r.re != nil {
+		if m := r.re.FindStringSubmatchIndex(key); m != nil {
+			if len(m) > 2 && m[2] >= 0 {
+				key = key[m[2]:m[3]]
+			} else {
+				key = key[m[0]:m[1]]
+			}
+		}
+	}
+	return key, true
+}
+
+// readMapping reads a mapping of station names from the CSV file at path. Each
+// record has a station name followed by the name it maps to. Lines beginning
+// with '#' are ignored.
+func readMapping(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.Comment = '#'
+	m := make(map[string]string)
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if prev, ok := m[rec[0]]; ok && prev != rec[1] {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %q is mapped to both %q and %q", path, line, rec[0], prev, rec[1])
+		}
+		m[rec[0]] = rec[1]
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_keyRewriter(t *testing.T) {
+	t.Parallel()
+
+	aliases := map[string]string{"NYC": "New York", "New York City": "New York"}
+	groups := map[string]string{"New York": "US", "Boston": "US"}
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected map[string]string
+	}{
+		"aliases": {
+			opts: &Options{Aliases: aliases},
+			expected: map[string]string{
+				"NYC":           "New York",
+				"New York":      "New York",
+				"New York City": "New York",
+				"Boston":        "Boston",
+			},
+		},
+		"groups": {
+			opts: &Options{Groups: groups},
+			expected: map[string]string{
+				"NYC":           "NYC",
+				"New York":      "US",
+				"New York City": "New York City",
+				"Boston":        "US",
+			},
+		},
+		"aliases and groups": {
+			opts: &Options{Aliases: aliases, Groups: groups},
+			expected: map[string]string{
+				"NYC":           "US",
+				"New York":      "US",
+				"New York City": "US",
+				"Boston":        "US",
+			},
+		},
+		"group match": {
+			opts: &Options{GroupMatch: `^New (\S+)`},
+			expected: map[string]string{
+				"NYC":           "NYC",
+				"New York":      "York",
+				"New York City": "York",
+				"Boston":        "Boston",
+			},
+		},
+		"group match without groups": {
+			opts: &Options{GroupMatch: `^\S+`},
+			expected: map[string]string{
+				"NYC":           "NYC",
+				"New York":      "New",
+				"New York City": "New",
+				"Boston":        "Boston",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := newKeyRewriter(tc.opts)
+			if err != nil {
+				t.Fatalf("newKeyRewriter: %v", err)
+			}
+			got := make(map[string]string)
+			for n := range tc.expected {
+				key, keep := r.rewrite(n)
+				if !keep {
+					t.Fatalf("rewrite(%q): station not kept", n)
+				}
+				got[n] = key
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if r, err := newKeyRewriter(&Options{}); r != nil || err != nil {
+		t.Fatalf("newKeyRewriter: want nil, got %v, %v", r, err)
+	}
+	if _, err := newKeyRewriter(&Options{Groups: groups, GroupMatch: "."}); err == nil {
+		t.Fatalf("newKeyRewriter: expected error for groups with group match")
+	}
+	if _, err := newKeyRewriter(&Options{GroupMatch: "("}); err == nil {
+		t.Fatalf("newKeyRewriter: expected error for invalid regular expression")
+	}
+}
+
+func Test_processFile_group(t *testing.T) {
+	t.Parallel()
+
+	aliases := map[string]string{"NYC": "New York", "New York City": "New York"}
+
+	testCases := map[string]struct {
+		path     string
+		opts     *Options
+		expected map[string]*TempInfo
+	}{
+		"aliases": {
+			path: "test/options/measurements-aliases.txt",
+			opts: &Options{Aliases: aliases},
+			expected: map[string]*TempInfo{
+				"New York": {Min: -20, Max: 300, Sum: 580, Count: 4},
+				"Boston":   {Min: 50, Max: 50, Sum: 50, Count: 1},
+			},
+		},
+		"groups": {
+			path: "test/options/measurements-aliases.txt",
+			opts: &Options{Aliases: aliases, Groups: map[string]string{"New York": "US", "Boston": "US"}},
+			expected: map[string]*TempInfo{
+				"US": {Min: -20, Max: 300, Sum: 630, Count: 5},
+			},
+		},
+		"group match": {
+			path: "test/options/measurements-aliases.txt",
+			opts: &Options{GroupMatch: `^\S+`},
+			expected: map[string]*TempInfo{
+				"NYC":    {Min: -20, Max: 100, Sum: 80, Count: 2},
+				"New":    {Min: 200, Max: 300, Sum: 500, Count: 2},
+				"Boston": {Min: 50, Max: 50, Sum: 50, Count: 1},
+			},
+		},
+		"filter input names": {
+			path: "test/options/measurements-aliases.txt",
+			opts: &Options{Aliases: aliases, Prefix: "New"},
+			expected: map[string]*TempInfo{
+				"New York": {Min: 200, Max: 300, Sum: 500, Count: 2},
+			},
+		},
+		"default path": {
+			path: "test/options/measurements-aliases.txt",
+			opts: &Options{Aliases: aliases, SkipBlank: true},
+			expected: map[string]*TempInfo{
+				"New York": {Min: -20, Max: 300, Sum: 580, Count: 4},
+				"Boston":   {Min: 50, Max: 50, Sum: 50, Count: 1},
+			},
+		},
+		"windowed": {
+			path: "test/options/measurements-timestamps.txt",
+			opts: &Options{Window: 24 * time.Hour, Aliases: map[string]string{"Zagreb": "Halifax"}},
+			expected: map[string]*TempInfo{
+				"2026-10-17T00:00:00ZHalifax": {Min: -31, Max: 129, Sum: 284, Count: 5},
+				"2026-10-18T00:00:00ZHalifax": {Min: -5, Max: -5, Sum: -5, Count: 1},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, size := range []int{5, 16, chunkSize} {
+				f, err := os.Open(tc.path)
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				defer f.Close()
+
+				m, err := processFile(f, size, tc.opts)
+				if err != nil {
+					t.Fatalf("processFile(%d): %v", size, err)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFile(%d): unexpected result (-want, +got):\n%s", size, diff)
+				}
+
+				m, err = processFileRandom(tc.path, size, tc.opts)
+				if err != nil {
+					t.Fatalf("processFileRandom(%d): %v", size, err)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFileRandom(%d): unexpected result (-want, +got):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_parser_rewriteOnce(t *testing.T) {
+	t.Parallel()
+
+	// More unique names than fit in the results for a chunk.
+	const n = 70000
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "s%d;1.0\n", i)
+	}
+	chunk := b.Bytes()
+
+	for name, opts := range map[string]*Options{
+		"fast path":    {Aliases: map[string]string{"s0": "first"}},
+		"default path": {Aliases: map[string]string{"s0": "first"}, SkipBlank: true},
+	} {
+		p := newParser(opts)
+		// Names are normalized before they are rewritten so counting
+		// normalizations counts rewrites.
+		var rewrites int
+		p.normalize = func(name []byte) []byte {
+			rewrites++
+			return name
+		}
+
+		for i := 0; i < 3; i++ {
+			m, err := p.processChunk(chunk, 0)
+			if err != nil {
+				t.Fatalf("%s: processChunk: %v", name, err)
+			}
+			if diff := cmp.Diff(n, len(m)); diff != "" {
+				t.Fatalf("%s: unexpected number of stations (-want, +got):\n%s", name, diff)
+			}
+			if _, ok := m["first"]; !ok {
+				t.Fatalf("%s: missing alias %q", name, "first")
+			}
+		}
+		if diff := cmp.Diff(n, rewrites); diff != "" {
+			t.Errorf("%s: unexpected number of rewrites (-want, +got):\n%s", name, diff)
+		}
+	}
+}
+
+func Test_readMapping(t *testing.T) {
+	t.Parallel()
+
+	got, err := readMapping("test/options/aliases.csv")
+	if err != nil {
+		t.Fatalf("readMapping: %v", err)
+	}
+	want := map[string]string{"NYC": "New York", "New York City": "New York"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	if _, err := readMapping("test/options/aliases-conflict.csv"); err == nil {
+		t.Fatalf("readMapping: expected error for conflicting mappings")
+	}
+	if _, err := readMapping("test/options/measurements-aliases.txt"); err == nil {
+		t.Fatalf("readMapping: expected error for invalid records")
+	}
+}
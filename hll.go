@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"runtime"
+	"strconv"
+)
+
+const (
+	// minPrecision and maxPrecision are the bounds of the precision of a
+	// HyperLogLog.
+	minPrecision = 4
+	maxPrecision = 18
+
+	// defaultPrecision is the default precision of a HyperLogLog. It has
+	// a relative standard error of about 0.8%.
+	defaultPrecision = 14
+)
+
+// hyperLogLog estimates the number of distinct station names it has seen
+// using 2^precision registers. HyperLogLogs with the same precision can be
+// merged. Names are hashed with a fixed hash function so HyperLogLogs computed
+// separately, for example for different files, can also be merged.
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// newHyperLogLog returns a new empty HyperLogLog with the given precision.
+func newHyperLogLog(precision int) (*hyperLogLog, error) {
+	if precision < minPrecision || precision > maxPrecision {
+		return nil, fmt.Errorf("invalid precision %d: must be between %d and %d", precision, minPrecision, maxPrecision)
+	}
+	return &hyperLogLog{
+		precision: uint8(precision),
+		registers: make([]uint8, 1<<precision),
+	}, nil
+}
+
+// hashName returns the 64-bit hash of a station name. It is FNV-1a followed by
+// the MurmurHash3 finalizer so that all bits of the hash are well mixed.
+func hashName(name []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range name {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// add adds a station name.
+func (h *hyperLogLog) add(name []byte) {
+	x := hashName(name)
+	i := x >> (64 - h.precision)
+	// Set a bit below the remaining bits so the count of leading zeros is
+	// at most 64-precision.
+	w := x<<h.precision | 1<<(h.precision-1)
+	if rho := uint8(bits.LeadingZeros64(w) + 1); rho > h.registers[i] {
+		h.registers[i] = rho
+	}
+}
+
+// merge merges o into h so that h estimates the number of distinct names seen
+// by either.
+func (h *hyperLogLog) merge(o *hyperLogLog) error {
+	if h.precision != o.precision {
+		return fmt.Errorf("cannot merge precision %d with precision %d", o.precision, h.precision)
+	}
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// estimate returns the estimated number of distinct names. Small numbers of
+// names are estimated with linear counting.
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+// relativeError returns the relative standard error of the estimate.
+func (h *hyperLogLog) relativeError() float64 {
+	return 1.04 / math.Sqrt(float64(len(h.registers)))
+}
+
+// countStations returns a HyperLogLog with the given precision of the station
+// names in the files at paths. Station names are processed as they are for
+// results, so options such as filters and aliases apply, but no result map is
+// built.
+func countStations(paths []string, precision int, opts *Options) (*hyperLogLog, error) {
+	if opts.Window > 0 {
+		return nil, errors.New("stations in windowed results cannot be counted")
+	}
+	h, err := newHyperLogLog(precision)
+	if err != nil {
+		return nil, err
+	}
+
+	parsers := make([]*parser, runtime.GOMAXPROCS(0))
+	sketches := make([]*hyperLogLog, len(parsers))
+	for i := range parsers {
+		parsers[i] = newParser(opts)
+		sketches[i], _ = newHyperLogLog(precision)
+	}
+	for _, path := range paths {
+		if err := countFileStations(path, parsers, sketches); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, s := range sketches {
+		if err := h.merge(s); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// countFileStations adds the station names in the file at path to sketches.
+// Each parser adds to the sketch with the same index.
+func countFileStations(path string, parsers []*parser, sketches []*hyperLogLog) error {
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	return scanDataRandom(data, segmentSize, parsers, func(i int, chunk []byte, offset int64) error {
+		h := sketches[i]
+		return parsers[i].eachLine(chunk, offset, func(name []byte, _ int, _ int64) {
+			h.add(name)
+		})
+	})
+}
+
+// stationCountJSON is the JSON encoding of an estimated number of stations.
+type stationCountJSON struct {
+	Stations      int     `json:"stations"`
+	Precision     int     `json:"precision"`
+	RelativeError float64 `json:"relative_error"`
+}
+
+// writeStationCount writes the estimated number of stations to w in the
+// format given by opts.
+func writeStationCount(w io.Writer, h *hyperLogLog, opts *Options) error {
+	r := stationCountJSON{
+		Stations:      int(math.Round(h.estimate())),
+		Precision:     int(h.precision),
+		RelativeError: h.relativeError(),
+	}
+
+	bw := bufio.NewWriter(w)
+	switch opts.format() {
+	case formatJSON:
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+		bw.WriteByte('\n')
+	case formatCSV:
+		cw := csv.NewWriter(bw)
+		cw.Write([]string{"stations", "precision", "relative_error"})
+		cw.Write([]string{strconv.Itoa(r.Stations), strconv.Itoa(r.Precision), strconv.FormatFloat(r.RelativeError, 'f', -1, 64)})
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	default:
+		fmt.Fprintln(bw, r.Stations)
+	}
+	return bw.Flush()
+}
+
+// countStationsCmd implements the count-stations command which estimates the
+// number of distinct stations in one or more files.
+func countStationsCmd(args []string, opts *Options, w io.Writer) error {
+	fs := flag.NewFlagSet("count-stations", flag.ContinueOnError)
+	precision := fs.Int("precision", defaultPrecision, fmt.Sprintf("use 2^`p` registers (%d to %d); higher is more accurate", minPrecision, maxPrecision))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("count-stations: expected at least one input file")
+	}
+
+	h, err := countStations(fs.Args(), *precision, opts)
+	if err != nil {
+		return fmt.Errorf("count-stations: %w", err)
+	}
+	return writeStationCount(w, h, opts)
+}
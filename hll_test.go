@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_hyperLogLog(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		precision int
+		n         int
+	}{
+		"small": {
+			precision: 14,
+			n:         100,
+		},
+		"low precision": {
+			precision: 8,
+			n:         10000,
+		},
+		"medium": {
+			precision: 12,
+			n:         50000,
+		},
+		"large": {
+			precision: 14,
+			n:         500000,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h, err := newHyperLogLog(tc.precision)
+			if err != nil {
+				t.Fatalf("newHyperLogLog: %v", err)
+			}
+			for i := 0; i < tc.n; i++ {
+				name := []byte("station" + strconv.Itoa(i))
+				// Duplicates do not change the estimate.
+				h.add(name)
+				h.add(name)
+			}
+
+			got := h.estimate()
+			if err := math.Abs(got-float64(tc.n)) / float64(tc.n); err > 3*h.relativeError() {
+				t.Fatalf("estimated %v for %d names: relative error %v is more than three times %v", got, tc.n, err, h.relativeError())
+			}
+		})
+	}
+}
+
+func Test_hyperLogLog_merge(t *testing.T) {
+	t.Parallel()
+
+	all, _ := newHyperLogLog(defaultPrecision)
+	left, _ := newHyperLogLog(defaultPrecision)
+	right, _ := newHyperLogLog(defaultPrecision)
+	for i := 0; i < 20000; i++ {
+		name := []byte(fmt.Sprintf("station%d", i))
+		all.add(name)
+		if i%3 == 0 {
+			left.add(name)
+		} else {
+			right.add(name)
+		}
+	}
+	if err := left.merge(right); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if diff := cmp.Diff(all.registers, left.registers); diff != "" {
+		t.Fatalf("unexpected registers (-want, +got):\n%s", diff)
+	}
+
+	other, _ := newHyperLogLog(defaultPrecision - 1)
+	if err := left.merge(other); err == nil {
+		t.Fatal("merge: expected error for different precisions")
+	}
+}
+
+func Test_newHyperLogLog(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []int{minPrecision - 1, maxPrecision + 1} {
+		if _, err := newHyperLogLog(p); err == nil {
+			t.Errorf("newHyperLogLog(%d): expected error", p)
+		}
+	}
+}
+
+func Test_countStationsCmd(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		args     []string
+		opts     *Options
+		expected int
+	}{
+		"unique keys": {
+			args:     []string{"test/measurements-10000-unique-keys.txt"},
+			opts:     &Options{},
+			expected: 10000,
+		},
+		"precision": {
+			args:     []string{"-precision", "10", "test/measurements-10000-unique-keys.txt"},
+			opts:     &Options{},
+			expected: 10000,
+		},
+		"prefix": {
+			args:     []string{"test/measurements-10000-unique-keys.txt"},
+			opts:     &Options{Prefix: "id1"},
+			expected: 1112,
+		},
+		"multiple files": {
+			args:     []string{"test/measurements-3.txt", "test/measurements-10.txt", "test/measurements-3.txt"},
+			opts:     &Options{},
+			expected: 12,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := countStationsCmd(tc.args, tc.opts, &b); err != nil {
+				t.Fatalf("countStationsCmd: %v", err)
+			}
+			got, err := strconv.Atoi(strings.TrimSpace(b.String()))
+			if err != nil {
+				t.Fatalf("unexpected output %q: %v", b.String(), err)
+			}
+			// Allow three times the relative standard error at the
+			// lowest precision used.
+			if math.Abs(float64(got-tc.expected)) > 3*0.0325*float64(tc.expected) {
+				t.Fatalf("want about %d stations, got %d", tc.expected, got)
+			}
+		})
+	}
+}
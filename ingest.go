@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultBatchSize is the default maximum size of the chunks read from
+	// ingest connections.
+	defaultBatchSize = 64 * 1024 // 64kb
+
+	// defaultDrainTimeout is the default time connections have to send
+	// in-flight lines when the ingest server shuts down.
+	defaultDrainTimeout = time.Second
+
+	// maxClosedConns is the number of closed connections for which
+	// statistics are kept.
+	maxClosedConns = 1000
+)
+
+// ConnStats are the statistics for an ingest connection.
+type ConnStats struct {
+	ID     int
+	Remote string
+
+	// Bytes is the number of bytes of complete lines received.
+	Bytes int64
+
+	// Errors is the number of invalid lines received.
+	Errors int
+
+	// LastError is the most recent error for the connection.
+	LastError string
+
+	Closed bool
+}
+
+// ingestChunk is a chunk of input received on a connection.
+type ingestChunk struct {
+	inputChunk
+	conn *ConnStats
+}
+
+// ingester aggregates lines received on connections into a live result.
+// Chunks of lines are processed by a fixed number of workers. Connections are
+// not read while the workers are busy so that clients are slowed down by the
+// transport's flow control.
+type ingester struct {
+	opts      *Options
+	batchSize int
+
+	// drainTimeout is the time connections have to send in-flight lines on
+	// shutdown.
+	drainTimeout time.Duration
+
+	work   chan ingestChunk
+	workWG sync.WaitGroup
+
+	acceptWG sync.WaitGroup
+	connWG   sync.WaitGroup
+
+	mu        sync.Mutex
+	m         map[string]*TempInfo
+	listeners []net.Listener
+	conns     map[net.Conn]*ConnStats
+	closed    []*ConnStats
+	nextID    int
+	stopping  bool
+}
+
+// newIngester returns a new ingester with the given number of workers.
+func newIngester(opts *Options, workers, batchSize int) *ingester {
+	ing := &ingester{
+		opts:         opts,
+		batchSize:    batchSize,
+		drainTimeout: defaultDrainTimeout,
+		work:         make(chan ingestChunk, workers*2),
+		m:            make(map[string]*TempInfo, maxCities),
+		conns:        make(map[net.Conn]*ConnStats),
+	}
+	for i := 0; i < workers; i++ {
+		p := newParser(opts)
+		p.collect = true
+		ing.workWG.Add(1)
+		go ing.process(p)
+	}
+	return ing
+}
+
+// serve accepts connections on l until the ingester is shut down.
+func (ing *ingester) serve(l net.Listener) {
+	ing.mu.Lock()
+	if ing.stopping {
+		ing.mu.Unlock()
+		l.Close()
+		return
+	}
+	ing.listeners = append(ing.listeners, l)
+	ing.acceptWG.Add(1)
+	ing.mu.Unlock()
+	defer ing.acceptWG.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			ing.mu.Lock()
+			stopping := ing.stopping
+			ing.mu.Unlock()
+			if !stopping {
+				log.Printf("ingest: accept: %v", err)
+			}
+			return
+		}
+
+		ing.mu.Lock()
+		ing.nextID++
+		cs := &ConnStats{
+			ID:     ing.nextID,
+			Remote: conn.RemoteAddr().String(),
+		}
+		ing.conns[conn] = cs
+		if ing.stopping {
+			conn.SetReadDeadline(time.Now().Add(ing.drainTimeout))
+		}
+		ing.connWG.Add(1)
+		ing.mu.Unlock()
+		go ing.handle(conn, cs)
+	}
+}
+
+// handle reads lines from conn and sends them to the workers in chunks.
+func (ing *ingester) handle(conn net.Conn, cs *ConnStats) {
+	defer ing.connWG.Done()
+	defer conn.Close()
+
+	chunks := make(chan inputChunk)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		cr := newChunkReader(ing.batchSize, newParser(ing.opts))
+		err := cr.read(conn, chunks)
+		if err == nil {
+			// The client closed the connection so the partial line
+			// at the end is complete.
+			cr.flush(chunks)
+		}
+		errc <- err
+	}()
+	for c := range chunks {
+		ing.mu.Lock()
+		cs.Bytes += int64(len(c.data))
+		ing.mu.Unlock()
+		ing.work <- ingestChunk{inputChunk: c, conn: cs}
+	}
+	err := <-errc
+
+	ing.mu.Lock()
+	defer ing.mu.Unlock()
+	var nerr net.Error
+	switch {
+	case err == nil:
+	case ing.stopping && errors.As(err, &nerr) && nerr.Timeout():
+		// The connection was closed on shutdown.
+	default:
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			cs.Errors++
+		}
+		cs.LastError = err.Error()
+	}
+	cs.Closed = true
+	delete(ing.conns, conn)
+	ing.closed = append(ing.closed, cs)
+	if len(ing.closed) > maxClosedConns {
+		ing.closed = ing.closed[len(ing.closed)-maxClosedConns:]
+	}
+}
+
+// process processes chunks with p and merges the results.
+func (ing *ingester) process(p *parser) {
+	defer ing.workWG.Done()
+	for c := range ing.work {
+		n := p.numViolations
+		m, err := p.processChunk(c.data, c.offset)
+		c.done()
+		n = p.numViolations - n
+		var lastErr error
+		if p.lastViolation != nil {
+			lastErr = p.lastViolation
+			p.lastViolation = nil
+		}
+		if err != nil {
+			n++
+			lastErr = err
+		}
+
+		ing.mu.Lock()
+		if err == nil {
+			mergeMap(ing.m, m)
+		}
+		c.conn.Errors += n
+		if lastErr != nil {
+			c.conn.LastError = lastErr.Error()
+		}
+		ing.mu.Unlock()
+	}
+}
+
+// snapshot returns a copy of the current results and the statistics for open
+// and recently closed connections ordered by ID.
+func (ing *ingester) snapshot() (*partial, []ConnStats) {
+	ing.mu.Lock()
+	defer ing.mu.Unlock()
+
+	p := newPartial(ing.opts, make(map[string]*TempInfo, len(ing.m)))
+	for k, v := range ing.m {
+		p.Stations[k] = v.clone()
+	}
+
+	stats := make([]ConnStats, 0, len(ing.closed)+len(ing.conns))
+	for _, cs := range ing.closed {
+		stats = append(stats, *cs)
+	}
+	for _, cs := range ing.conns {
+		stats = append(stats, *cs)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+	return p, stats
+}
+
+// shutdown stops accepting connections, gives open connections drainTimeout
+// to send in-flight lines, and waits until all received lines are processed.
+func (ing *ingester) shutdown() {
+	ing.mu.Lock()
+	ing.stopping = true
+	for _, l := range ing.listeners {
+		l.Close()
+	}
+	deadline := time.Now().Add(ing.drainTimeout)
+	for conn := range ing.conns {
+		conn.SetReadDeadline(deadline)
+	}
+	ing.mu.Unlock()
+
+	ing.acceptWG.Wait()
+	ing.connWG.Wait()
+	close(ing.work)
+	ing.workWG.Wait()
+}
+
+// SnapshotArgs are the arguments to the Ingest.Snapshot RPC.
+type SnapshotArgs struct{}
+
+// SnapshotReply is the reply to the Ingest.Snapshot RPC.
+type SnapshotReply struct {
+	// Partial is the binary encoded partial result.
+	Partial []byte
+
+	Conns []ConnStats
+}
+
+// Ingest is the control service of an ingest server.
+type Ingest struct {
+	ing *ingester
+}
+
+// Snapshot returns the current results of the ingest server.
+func (s *Ingest) Snapshot(args *SnapshotArgs, reply *SnapshotReply) error {
+	p, stats := s.ing.snapshot()
+	var b bytes.Buffer
+	if err := writePartial(&b, p, formatBinary); err != nil {
+		return err
+	}
+	reply.Partial = b.Bytes()
+	reply.Conns = stats
+	return nil
+}
+
+// serveControl serves the Ingest RPC service for ing on l until l is closed.
+func serveControl(l net.Listener, ing *ingester) {
+	s := rpc.NewServer()
+	if err := s.Register(&Ingest{ing: ing}); err != nil {
+		panic(err)
+	}
+	s.Accept(l)
+}
+
+// splitAddr returns the network and address for addr. Addresses of the form
+// unix:path are Unix domain sockets and all others are TCP addresses.
+func splitAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// ingestCmd implements the ingest command which aggregates lines received on
+// the listen addresses until interrupted and then writes the result.
+func ingestCmd(args []string, opts *Options) (*partial, error) {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	listen := fs.String("listen", ":7071", "comma separated list of `addresses` to receive lines on (unix:path for a Unix domain socket)")
+	control := fs.String("control", "localhost:7072", "serve snapshots on `address` (unix:path for a Unix domain socket)")
+	batchSize := fs.String("batch-size", "", "maximum size of chunks read from connections (default 64K)")
+	drain := fs.Duration("drain-timeout", defaultDrainTimeout, "time connections have to send in-flight lines on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("ingest: unexpected arguments: %v", fs.Args())
+	}
+	size, err := parseSize(*batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -batch-size: %w", err)
+	}
+	if size == 0 {
+		size = defaultBatchSize
+	}
+
+	ing := newIngester(opts, runtime.NumCPU(), int(size))
+	ing.drainTimeout = *drain
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, addr := range strings.Split(*listen, ",") {
+		l, err := net.Listen(splitAddr(addr))
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	cl, err := net.Listen(splitAddr(*control))
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	defer cl.Close()
+	go serveControl(cl, ing)
+
+	for _, l := range listeners {
+		log.Printf("ingest: listening on %s", l.Addr())
+		go ing.serve(l)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	signal.Stop(sig)
+
+	log.Printf("ingest: shutting down")
+	ing.shutdown()
+	p, _ := ing.snapshot()
+	return p, nil
+}
+
+// snapshotCmd implements the snapshot command which returns the current
+// results of an ingest server. Connection statistics are written to w if
+// requested.
+func snapshotCmd(args []string, w io.Writer) (*partial, error) {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:7072", "control `address` of the ingest server")
+	conns := fs.Bool("conns", false, "write connection statistics to stderr")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	client, err := rpc.Dial(splitAddr(*addr))
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var reply SnapshotReply
+	if err := client.Call("Ingest.Snapshot", &SnapshotArgs{}, &reply); err != nil {
+		return nil, err
+	}
+	if *conns {
+		for _, cs := range reply.Conns {
+			state := "open"
+			if cs.Closed {
+				state = "closed"
+			}
+			fmt.Fprintf(w, "conn %d %s %s: %d bytes, %d errors", cs.ID, cs.Remote, state, cs.Bytes, cs.Errors)
+			if cs.LastError != "" {
+				fmt.Fprintf(w, ", last error: %s", cs.LastError)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return readPartial(bytes.NewReader(reply.Partial))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// startIngester starts an ingester listening on a TCP and a Unix domain socket
+// and returns it with the listen addresses.
+func startIngester(t *testing.T, batchSize int) (*ingester, []string) {
+	t.Helper()
+
+	ing := newIngester(&Options{}, 2, batchSize)
+	var addrs []string
+	for _, addr := range []string{"127.0.0.1:0", "unix:" + filepath.Join(t.TempDir(), "ingest.sock")} {
+		l, err := net.Listen(splitAddr(addr))
+		if err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		addrs = append(addrs, l.Addr().Network()+":"+l.Addr().String())
+		go ing.serve(l)
+	}
+	return ing, addrs
+}
+
+// dialIngest connects to addr as returned by startIngester.
+func dialIngest(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	network, address, _ := strings.Cut(addr, ":")
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	return conn
+}
+
+func Test_ingester(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		// sends are the data sent on each connection.
+		sends []string
+
+		// valid is the valid input sent on all connections.
+		valid string
+
+		errors []int
+	}{
+		"single connection": {
+			sends:  []string{"foo;1.0\nbar;2.0\nfoo;-3.0\n"},
+			valid:  "foo;1.0\nbar;2.0\nfoo;-3.0\n",
+			errors: []int{0},
+		},
+		"multiple connections": {
+			sends: []string{
+				"foo;1.0\nbar;2.0\n",
+				"foo;-3.0\nbaz;12.5\n",
+				"Abéché;10.1\n",
+			},
+			valid:  "foo;1.0\nbar;2.0\nfoo;-3.0\nbaz;12.5\nAbéché;10.1\n",
+			errors: []int{0, 0, 0},
+		},
+		"errors": {
+			sends: []string{
+				"foo;1.0\nbar;x\nbar;2.0\nbaz\n",
+				"foo;-3.0\n",
+			},
+			valid:  "foo;1.0\nbar;2.0\nfoo;-3.0\n",
+			errors: []int{2, 0},
+		},
+		"last line without newline": {
+			sends:  []string{"foo;1.0\nbar;2.0"},
+			valid:  "foo;1.0\nbar;2.0\n",
+			errors: []int{0},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Use a small batch size so lines span reads.
+			ing, addrs := startIngester(t, 8)
+			for i, data := range tc.sends {
+				conn := dialIngest(t, addrs[i%len(addrs)])
+				if _, err := conn.Write([]byte(data)); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				conn.Close()
+			}
+
+			// Wait for the connections to close.
+			for {
+				_, stats := ing.snapshot()
+				closed := 0
+				for _, cs := range stats {
+					if cs.Closed {
+						closed++
+					}
+				}
+				if closed == len(tc.sends) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			ing.shutdown()
+
+			want, err := processFile(strings.NewReader(tc.valid), chunkSize, nil)
+			if err != nil {
+				t.Fatalf("processFile: %v", err)
+			}
+			p, stats := ing.snapshot()
+			if diff := cmp.Diff(&partial{Scale: 1, Stations: want}, p); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+
+			// Connections are accepted concurrently so compare
+			// the sorted counts.
+			var errors []int
+			for _, cs := range stats {
+				errors = append(errors, cs.Errors)
+			}
+			if diff := cmp.Diff(tc.errors, errors, cmpopts.SortSlices(func(a, b int) bool { return a < b })); diff != "" {
+				t.Fatalf("unexpected errors (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_ingester_shutdown(t *testing.T) {
+	t.Parallel()
+
+	ing, addrs := startIngester(t, defaultBatchSize)
+	ing.drainTimeout = 50 * time.Millisecond
+
+	// Leave the connection open with a partial line.
+	conn := dialIngest(t, addrs[0])
+	defer conn.Close()
+	if _, err := conn.Write([]byte("foo;1.0\nbar;2.0\nbaz;3")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Wait for the complete lines to be received.
+	for {
+		_, stats := ing.snapshot()
+		if len(stats) == 1 && stats[0].Bytes == 16 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ing.shutdown()
+
+	p, stats := ing.snapshot()
+	want := map[string]*TempInfo{
+		"foo": {Min: 10, Max: 10, Sum: 10, Count: 1},
+		"bar": {Min: 20, Max: 20, Sum: 20, Count: 1},
+	}
+	if diff := cmp.Diff(want, p.Stations); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+	if len(stats) != 1 || !stats[0].Closed || stats[0].LastError != "" {
+		t.Fatalf("unexpected connection stats: %+v", stats)
+	}
+
+	// New connections are refused.
+	network, address, _ := strings.Cut(addrs[1], ":")
+	if conn, err := net.Dial(network, address); err == nil {
+		conn.Close()
+		t.Fatalf("expected connection to be refused")
+	}
+}
+
+func Test_ingester_lastError(t *testing.T) {
+	t.Parallel()
+
+	ing, addrs := startIngester(t, defaultBatchSize)
+	defer ing.shutdown()
+
+	conn := dialIngest(t, addrs[0])
+	defer conn.Close()
+	if _, err := conn.Write([]byte("foo;x\nbar;y\nbaz;1.0\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// The most recent error is kept, not the first in the chunk.
+	for {
+		_, stats := ing.snapshot()
+		if len(stats) != 1 || stats[0].Errors < 2 {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if stats[0].Errors != 2 || !strings.HasPrefix(stats[0].LastError, "offset 6: ") {
+			t.Fatalf("unexpected connection stats: %+v", stats)
+		}
+		break
+	}
+}
+
+func Test_snapshotCmd(t *testing.T) {
+	t.Parallel()
+
+	ing, addrs := startIngester(t, defaultBatchSize)
+	defer ing.shutdown()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	go serveControl(l, ing)
+
+	conn := dialIngest(t, addrs[0])
+	defer conn.Close()
+	if _, err := conn.Write([]byte("foo;1.0\nfoo;x\nfoo;3.0\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	want := &partial{
+		Scale: 1,
+		Stations: map[string]*TempInfo{
+			"foo": {Min: 10, Max: 30, Sum: 40, Count: 2},
+		},
+	}
+	for {
+		var w strings.Builder
+		p, err := snapshotCmd([]string{"-addr", l.Addr().String(), "-conns"}, &w)
+		if err != nil {
+			t.Fatalf("snapshotCmd: %v", err)
+		}
+		if !cmp.Equal(want, p) {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if !strings.Contains(w.String(), "open: 22 bytes, 1 errors, last error: offset 8: ") {
+			t.Fatalf("unexpected connection stats: %q", w.String())
+		}
+		break
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,11 +11,14 @@ import (
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
-	"sort"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 // TempInfo stores temperature stats for a single city.
@@ -22,6 +27,54 @@ type TempInfo struct {
 	Sum   int
 	Count int
 	Max   int
+
+	// Extra holds the stats for the value columns after the first when rows
+	// have multiple value columns. Columns without values have a Count of
+	// zero.
+	Extra []TempInfo
+}
+
+// ParseError is an error that occurred while parsing the input at a given byte
+// offset.
+type ParseError struct {
+	// Offset is the byte offset of the start of the offending line.
+	Offset int64
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// errLineTooLong returns a ParseError for a line starting at offset that
+// exceeds the maximum line length.
+func errLineTooLong(offset int64, maxLen int) error {
+	return &ParseError{
+		Offset: offset,
+		Err:    fmt.Errorf("%w: line exceeds maximum length of %d bytes", errInputFormat, maxLen),
+	}
+}
+
+// inputChunk is a chunk of input comprised of full lines.
+type inputChunk struct {
+	// offset is the byte offset of the start of the chunk in the input.
+	offset int64
+	data   []byte
+
+	// release, if not nil, returns the buffer holding data to the
+	// chunkReader that read it so that it can be reused.
+	release func()
+}
+
+// done must be called once data is no longer used.
+func (c inputChunk) done() {
+	if c.release != nil {
+		c.release()
+	}
 }
 
 const (
@@ -32,12 +85,61 @@ const (
 var (
 	errInputFormat = errors.New("bad input format")
 
+	maxLineLength    = flag.Int("max-line-length", 0, "maximum length in bytes of a line (0 for no limit)")
+	crlf             = flag.Bool("crlf", false, "accept CRLF line endings")
+	skipBOM          = flag.Bool("skip-bom", false, "skip a UTF-8 byte order mark at the start of the input")
+	commentPrefix    = flag.String("comment-prefix", "", "skip lines beginning with `prefix`")
+	skipBlank        = flag.Bool("skip-blank", false, "skip blank lines")
+	delim            = flag.String("delim", ";", "field delimiter (a single byte or \\t)")
+	decimal          = flag.String("decimal", ".", "decimal separator (a single byte)")
+	header           = flag.Bool("header", false, "skip the first line of the input")
+	scale            = flag.Int("scale", 1, "number of fractional digits kept for values")
+	strict           = flag.Bool("strict", false, "enforce the 1 billion row challenge input rules")
+	columns          = flag.String("columns", "", "comma separated `names` of multiple value columns (read from the header line with -header)")
+	timestamp        = flag.Bool("timestamp", false, "lines have a third field with an RFC 3339 timestamp")
+	window           = flag.String("window", "", "group results by tumbling windows of `duration` (e.g. 1h or 1d)")
+	stationsPath     = flag.String("stations", "", "only include stations named in `file` (one name per line)")
+	prefix           = flag.String("prefix", "", "only include stations with names beginning with `prefix`")
+	match            = flag.String("match", "", "only include stations with names matching the regular expression `regex`")
+	aliasesPath      = flag.String("aliases", "", "aggregate stations under canonical names from CSV `file` of name,canonical rows")
+	groupsPath       = flag.String("groups", "", "aggregate stations by groups from CSV `file` of name,group rows")
+	groupMatch       = flag.String("group-match", "", "aggregate stations by the first capturing group of `regex`")
+	metadataPath     = flag.String("metadata", "", "attach station metadata from CSV `file` with a header row to JSON and CSV results")
+	groupBy          = flag.String("group-by", "", "aggregate stations by the value of metadata `column`")
+	where            = flag.String("where", "", "only include stations with metadata matching comma separated column=value `conditions`")
+	normalize        = flag.String("normalize", normalizeNone, "Unicode normalization of station names (none, nfc or nfkc)")
+	foldCase         = flag.Bool("fold-case", false, "apply Unicode case folding to station names")
+	format           = flag.String("format", format1BRC, "output format (1brc, json or csv)")
+	sortOrder        = flag.String("sort", sortName, "order results by name, min, max, mean, count or range")
+	desc             = flag.Bool("desc", false, "reverse the order of results")
+	collation        = flag.String("collate", collateBytes, "order station names by `collation` (bytes or a BCP 47 language tag such as und)")
+	top              = flag.Int("top", 0, "only print the first `n` results (0 for all)")
+	unit             = flag.String("unit", unitCelsius, "temperature unit of results (C, F or K)")
+	unitSuffixes     = flag.Bool("unit-suffix", false, "accept values with a C, F or K unit suffix and convert them to Celsius")
+	partialOut       = flag.String("partial-out", "", "write a partial result to `file` (- for stdout) instead of printing the result")
+	partialFormat    = flag.String("partial-format", formatBinary, "partial result format (binary or json)")
+	memoryBudget     = flag.String("memory-budget", "", "approximate memory `size` (e.g. 512M) above which results are spilled to disk")
+	statePath        = flag.String("state", "", "keep results in state `file` and only process lines appended since the last run")
+	follow           = flag.Bool("follow", false, "follow the file as it grows and print results every -interval")
+	interval         = flag.Duration("interval", defaultInterval, "interval between results in -follow mode")
+	procs            = flag.Int("procs", 0, "process the file in `n` child processes (0 to use goroutines)")
+	sample           = flag.Float64("sample", 0, "estimate results from a random `fraction` of the file (0 to process the whole file)")
+	sampleSeed       = flag.Int64("sample-seed", 0, "random `seed` used to choose the sampled segments (0 for a random seed)")
+	confidence       = flag.Float64("confidence", defaultConfidence, "confidence `level` of sampled estimates")
 	cpuprofile       = flag.String("cpuprofile", "", "write cpu profile to `file`")
 	memprofile       = flag.String("memprofile", "", "write memory profile to `file`")
 	executionprofile = flag.String("execprofile", "", "write trace execution to `file`")
 )
 
 func main() {
+	if isProcChild() {
+		if err := procChild(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	if *executionprofile != "" {
@@ -62,16 +164,126 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	args := flag.Args()
-	if len(args) != 1 {
-		log.Fatalf("invalid arguments: %v", args)
-	}
-	f, err := os.Open(args[0])
+	opts, err := optionsFromFlags()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	m, err := processFile(f, chunkSize)
+	args := flag.Args()
+	if *partialOut != "" && opts.MemoryBudget > 0 {
+		log.Fatal("-partial-out cannot be used with -memory-budget")
+	}
+	if *procs < 0 {
+		log.Fatalf("invalid -procs: %d", *procs)
+	}
+	if *procs > 0 && opts.MemoryBudget > 0 {
+		log.Fatal("-procs cannot be used with -memory-budget")
+	}
+	if *statePath != "" && (opts.MemoryBudget > 0 || *procs > 0) {
+		log.Fatal("-state cannot be used with -memory-budget or -procs")
+	}
+	if *follow && (opts.MemoryBudget > 0 || *procs > 0 || *statePath != "") {
+		log.Fatal("-follow cannot be used with -memory-budget, -procs or -state")
+	}
+	if *interval <= 0 {
+		log.Fatalf("invalid -interval: %v", *interval)
+	}
+	if *sample < 0 || *sample > 1 {
+		log.Fatalf("invalid -sample: %v", *sample)
+	}
+	if *sample > 0 && (*partialOut != "" || opts.MemoryBudget > 0 || *procs > 0 || *statePath != "" || *follow) {
+		log.Fatal("-sample cannot be used with -partial-out, -memory-budget, -procs, -state or -follow")
+	}
+
+	if len(args) > 0 {
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		if err := checkModeFlags(args[0], set); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var m map[string]*TempInfo
+	var sm *spillMerger
+	switch {
+	case len(args) > 0 && args[0] == "serve":
+		if err := serveCmd(args[1:], opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && args[0] == "worker":
+		if err := workerCmd(args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && args[0] == "count-stations":
+		if err := countStationsCmd(args[1:], opts, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && args[0] == "baseline":
+		if err := baselineCmd(args[1:], opts, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && args[0] == "anomalies":
+		if err := anomaliesCmd(args[1:], opts, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && args[0] == "diff":
+		if err := diffCmd(args[1:], opts, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && args[0] == "validate":
+		if err := validateCmd(args[1:], opts, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case len(args) > 0 && (args[0] == "merge" || args[0] == "coordinator" || args[0] == "ingest" || args[0] == "snapshot"):
+		var p *partial
+		switch args[0] {
+		case "merge":
+			p, err = mergePartials(args[1:])
+		case "coordinator":
+			p, err = coordinatorCmd(args[1:], opts)
+		case "ingest":
+			p, err = ingestCmd(args[1:], opts)
+		case "snapshot":
+			p, err = snapshotCmd(args[1:], os.Stderr)
+		}
+		if err == nil {
+			m = p.Stations
+			p.options(opts)
+		}
+	case len(args) != 1:
+		log.Fatalf("invalid arguments: %v", args)
+	default:
+		if opts.Header && len(opts.Columns) == 0 {
+			if opts.Columns, err = headerColumns(args[0], opts); err != nil {
+				log.Fatal(err)
+			}
+			if err := opts.validate(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *follow {
+			if err := followFile(args[0], *interval, opts); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if *sample > 0 {
+			if err := sampleFile(args[0], opts); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		m, sm, err = processPath(args[0], opts)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -88,87 +300,466 @@ func main() {
 		}
 	}
 
-	printMap(m)
+	if missing := missingMetadata(m, opts); len(missing) > 0 {
+		log.Printf("%d stations missing from metadata: %s", len(missing), summarizeNames(missing, maxMissingReported))
+	}
+
+	if sm != nil {
+		defer sm.Close()
+		err = sm.writeTo(os.Stdout, opts)
+	} else {
+		err = writeResult(m, opts)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// modeFlags are the global flags that select how an input file is processed
+// and how its result is written.
+var modeFlags = []string{
+	"partial-out", "partial-format", "memory-budget", "state", "follow",
+	"interval", "procs", "sample", "sample-seed", "confidence",
+}
+
+// commandModeFlags maps each command to the mode flags it supports.
+var commandModeFlags = map[string][]string{
+	"serve":          nil,
+	"worker":         nil,
+	"count-stations": nil,
+	"baseline":       nil,
+	"anomalies":      nil,
+	"diff":           nil,
+	"validate":       nil,
+	"merge":          {"partial-out", "partial-format"},
+	"coordinator":    {"partial-out", "partial-format"},
+	"ingest":         {"partial-out", "partial-format"},
+	"snapshot":       {"partial-out", "partial-format"},
+}
+
+// checkModeFlags returns an error if any of the set flags is a mode flag
+// that is not supported by cmd. Arguments that are not commands are input
+// files, which support every mode flag.
+func checkModeFlags(cmd string, set map[string]bool) error {
+	supported, ok := commandModeFlags[cmd]
+	if !ok {
+		return nil
+	}
+	var unsupported []string
+	for _, name := range modeFlags {
+		if set[name] && !slices.Contains(supported, name) {
+			unsupported = append(unsupported, "-"+name)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s: unsupported flags: %s", cmd, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// processPath produces the result for the file at path in the mode selected by
+// the command line flags. Either a result map or a spillMerger holding the
+// results is returned.
+func processPath(path string, opts *Options) (map[string]*TempInfo, *spillMerger, error) {
+	switch {
+	case *statePath != "":
+		p, err := processFileIncremental(path, *statePath, opts, log.Printf)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p.Stations, nil, nil
+	case *procs > 0:
+		m, err := processFileProcs(path, *procs, opts)
+		return m, nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if opts.MemoryBudget > 0 {
+		sm, err := processFileSpill(f, opts)
+		return nil, sm, err
+	}
+	m, err := processFile(f, chunkSize, opts)
+	return m, nil, err
+}
+
+// writeResult writes the result map to stdout, or as a partial result if
+// -partial-out is set.
+func writeResult(m map[string]*TempInfo, opts *Options) error {
+	if *partialOut == "" {
+		return printMap(os.Stdout, m, opts)
+	}
+	p := newPartial(opts, m)
+	return writePartialFile(*partialOut, p, *partialFormat)
+}
+
+// followFile follows the file at path and writes the results every interval
+// until interrupted.
+func followFile(path string, interval time.Duration, opts *Options) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fl, err := newFollower(path, opts)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+	return fl.follow(ctx, interval, func(m map[string]*TempInfo) error {
+		return writeResult(m, opts)
+	})
+}
+
+// sampleFile writes the results estimated from a random sample of the file at
+// path.
+func sampleFile(path string, opts *Options) error {
+	seed := *sampleSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r, err := processFileSample(path, segmentSize, *sample, *confidence, seed, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeSample(os.Stdout, r, opts); err != nil {
+		return err
+	}
+	if opts.format() != formatJSON {
+		log.Print(sampleSummary(r))
+	}
+	return nil
+}
+
+// optionsFromFlags returns the Options set by command line flags.
+func optionsFromFlags() (*Options, error) {
+	// A zero Options.Scale means the default, so reject an explicit zero.
+	if *scale < 1 || *scale > maxScale {
+		return nil, fmt.Errorf("invalid -scale: must be between 1 and %d", maxScale)
+	}
+
+	d, err := parseSeparator(*delim)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -delim: %w", err)
+	}
+	dec, err := parseSeparator(*decimal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -decimal: %w", err)
+	}
+
+	budget, err := parseSize(*memoryBudget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -memory-budget: %w", err)
+	}
+
+	w, err := parseWindow(*window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -window: %w", err)
+	}
+
+	var stations []string
+	if *stationsPath != "" {
+		if stations, err = readStations(*stationsPath); err != nil {
+			return nil, fmt.Errorf("invalid -stations: %w", err)
+		}
+	}
+
+	var md *Metadata
+	if *metadataPath != "" {
+		if md, err = readMetadata(*metadataPath); err != nil {
+			return nil, fmt.Errorf("invalid -metadata: %w", err)
+		}
+	}
+	conds, err := parseWhere(*where)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -where: %w", err)
+	}
+
+	var aliases, groups map[string]string
+	if *aliasesPath != "" {
+		if aliases, err = readMapping(*aliasesPath); err != nil {
+			return nil, fmt.Errorf("invalid -aliases: %w", err)
+		}
+	}
+	if *groupsPath != "" {
+		if groups, err = readMapping(*groupsPath); err != nil {
+			return nil, fmt.Errorf("invalid -groups: %w", err)
+		}
+	}
+
+	opts := &Options{
+		MaxLineLength: *maxLineLength,
+		CRLF:          *crlf,
+		SkipBOM:       *skipBOM,
+		CommentPrefix: *commentPrefix,
+		SkipBlank:     *skipBlank,
+		Delim:         d,
+		Decimal:       dec,
+		Header:        *header,
+		Scale:         *scale,
+		Strict:        *strict,
+		MemoryBudget:  budget,
+		Timestamp:     *timestamp,
+		Columns:       splitColumns(*columns),
+		Window:        w,
+		Stations:      stations,
+		Prefix:        *prefix,
+		Match:         *match,
+		Aliases:       aliases,
+		Groups:        groups,
+		GroupMatch:    *groupMatch,
+		Metadata:      md,
+		GroupBy:       *groupBy,
+		Where:         conds,
+		Normalize:     *normalize,
+		FoldCase:      *foldCase,
+		Format:        *format,
+		Sort:          *sortOrder,
+		Desc:          *desc,
+		Collate:       *collation,
+		Top:           *top,
+		Unit:          *unit,
+		UnitSuffix:    *unitSuffixes,
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// splitColumns splits a comma separated list of column names. An empty string
+// is no columns.
+func splitColumns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// headerColumns returns the names of the value columns from the header line of
+// the file at path or nil if there is only a single value column.
+func headerColumns(path string, opts *Options) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadSlice('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	if opts.SkipBOM {
+		line = bytes.TrimPrefix(line, utf8BOM)
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+
+	fields := strings.Split(string(line), string(opts.delim()))
+	if opts.timestamps() {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) <= 2 {
+		return nil, nil
+	}
+	return fields[1:], nil
 }
 
 // round rounds to the nearest tenth.
 func round(n float64) float64 {
-	r := math.Round(n * 10)
+	return roundScale(n, 1)
+}
+
+// roundScale rounds to the given number of fractional digits.
+func roundScale(n float64, scale int) float64 {
+	p := math.Pow10(scale)
+	r := math.Round(n * p)
 	if r == -0.0 {
 		return 0.0
 	}
-	return r / 10
+	return r / p
 }
 
-// printMap prints the result map in the format expected for the 1 billion row
-// challenge.
-func printMap(m map[string]*TempInfo) {
-	// Print the output alphabetically.
-	var keys []string
-	for k := range m {
-		keys = append(keys, k)
+// printMap prints the result map to w in the format expected for the 1
+// billion row challenge. Values are printed with opts.Scale fractional digits
+// and results are ordered by opts.Sort.
+func printMap(w io.Writer, m map[string]*TempInfo, opts *Options) error {
+	rw := newResultWriter(w, opts)
+	for _, r := range sortRecords(m, opts) {
+		rw.write(r.name, r.info)
 	}
-	sort.Strings(keys)
-	fmt.Print("{")
-	for i, k := range keys {
-		v := m[k]
-		fmt.Printf(
-			"%s=%.1f/%.1f/%.1f",
-			k,
-			round(float64(v.Min))/10,
-			round(float64(v.Sum)/10/float64(v.Count)),
-			round(float64(v.Max))/10,
-		)
-		if i != len(keys)-1 {
-			fmt.Print(", ")
-		}
+	return rw.close()
+}
+
+// chunkReader splits input read from a reader into chunks of full lines.
+// Lines that span reads are stitched together up to opts.MaxLineLength bytes.
+// A chunkReader keeps the partial line at the end of the input so that reading
+// can be resumed when more input is available.
+type chunkReader struct {
+	p    *parser
+	size int
+
+	// free holds read buffers that are no longer used by any chunk.
+	free chan []byte
+
+	// remainder is the partial line at the end of the input read so far. It
+	// is copied out of the read buffer.
+	remainder []byte
+
+	// offset is the offset of the start of remainder in the input.
+	offset int64
+
+	// started is true once the first line has been sent.
+	started bool
+}
+
+// maxFreeBuffers is the maximum number of unused read buffers kept by a
+// chunkReader.
+const maxFreeBuffers = 4
+
+// newChunkReader returns a new chunkReader that reads chunks of size bytes.
+func newChunkReader(size int, p *parser) *chunkReader {
+	return &chunkReader{
+		p:    p,
+		size: size,
+		free: make(chan []byte, maxFreeBuffers),
 	}
-	fmt.Print("}\n")
 }
 
-// readChunks reads chunks of size chunkSize from r and sends them to
-// chunkChan. If any errors occur, the error is sent to errChan and readChunks
-// returns immediately.
-func readChunks(r io.Reader, chunkSize int, chunkChan chan []byte, errChan chan error) {
-	defer close(chunkChan)
-	var remainder []byte
+// reset prepares c to read new input from the start. The read buffers are
+// kept for reuse.
+func (c *chunkReader) reset() {
+	c.remainder = c.remainder[:0]
+	c.offset = 0
+	c.started = false
+}
+
+// buffer returns an unused read buffer.
+func (c *chunkReader) buffer() []byte {
+	select {
+	case buf := <-c.free:
+		return buf
+	default:
+		return make([]byte, c.size)
+	}
+}
+
+// release returns buf to the free buffers unless there are enough already.
+func (c *chunkReader) release(buf []byte) {
+	select {
+	case c.free <- buf:
+	default:
+	}
+}
+
+// read reads chunks from r until io.EOF and sends the full lines to chunkChan.
+// The partial line at the end of the input is kept until more input is read
+// or flush is called.
+func (c *chunkReader) read(r io.Reader, chunkChan chan<- inputChunk) error {
+	opts := c.p.opts
 	for {
-		chunkRead, nextRemainder, readErr := readChunk(r, chunkSize)
+		buf := c.buffer()
+		chunkRead, nextRemainder, readErr := readChunk(r, buf)
 		if readErr != nil && !errors.Is(readErr, io.EOF) {
-			errChan <- readErr
-			return
+			c.release(buf)
+			return readErr
 		}
 
-		firstLine, chunk := fixRemainder(remainder, chunkRead)
-		if len(firstLine) > 0 {
-			chunkChan <- firstLine
-		}
-		if len(chunk) > 0 {
-			chunkChan <- chunk
+		if len(chunkRead) == 0 {
+			// No newline was read so the current line continues into the
+			// next read.
+			c.remainder = append(c.remainder, nextRemainder...)
+			c.release(buf)
+			if opts.MaxLineLength > 0 && len(c.remainder) > opts.MaxLineLength {
+				return errLineTooLong(c.offset, opts.MaxLineLength)
+			}
+		} else {
+			firstLine, chunk := fixRemainder(c.remainder, chunkRead)
+			if opts.MaxLineLength > 0 && len(firstLine)-1 > opts.MaxLineLength {
+				c.release(buf)
+				return errLineTooLong(c.offset, opts.MaxLineLength)
+			}
+			if !c.started {
+				if len(firstLine) > 0 {
+					firstLine = c.p.trimPreamble(firstLine)
+				} else {
+					chunk = c.p.trimPreamble(chunk)
+				}
+				c.started = true
+			}
+
+			end := c.offset + int64(len(c.remainder)+len(chunkRead))
+			if len(firstLine) > 0 {
+				chunkChan <- inputChunk{
+					offset: end - int64(len(chunk)+len(firstLine)),
+					data:   firstLine,
+				}
+			}
+
+			// The remainder is copied so that buf is only used by chunk.
+			c.offset = end
+			c.remainder = append(c.remainder[:0], nextRemainder...)
+			if len(chunk) > 0 {
+				chunkChan <- inputChunk{
+					offset:  end - int64(len(chunk)),
+					data:    chunk,
+					release: func() { c.release(buf) },
+				}
+			} else {
+				c.release(buf)
+			}
 		}
 
-		remainder = nextRemainder
 		if errors.Is(readErr, io.EOF) {
-			break
+			return nil
 		}
 	}
+}
 
-	// Handle the remainder if there is one.
+// flush sends the partial line at the end of the input to chunkChan as the
+// last line.
+func (c *chunkReader) flush(chunkChan chan<- inputChunk) {
+	remainder := c.remainder
+	end := c.offset + int64(len(remainder))
+	if !c.started {
+		remainder = c.p.trimPreamble(remainder)
+	}
 	if len(remainder) > 0 {
-		chunkChan <- remainder
+		chunkChan <- inputChunk{
+			offset: end - int64(len(remainder)),
+			data:   remainder,
+		}
 	}
+	c.offset = end
+	c.remainder = nil
+}
+
+// readChunks reads chunks of size chunkSize from r and sends them to
+// chunkChan. Lines longer than chunkSize are stitched together across reads up
+// to opts.MaxLineLength bytes. If any errors occur, the error is sent to
+// errChan and readChunks returns immediately.
+func readChunks(r io.Reader, chunkSize int, p *parser, chunkChan chan inputChunk, errChan chan error) {
+	defer close(chunkChan)
+	c := newChunkReader(chunkSize, p)
+	if err := c.read(r, chunkChan); err != nil {
+		errChan <- err
+		return
+	}
+	c.flush(chunkChan)
 }
 
 // processChunks reads chunks from chunkChan, processes each line in the chunk,
 // and sends the resulting map for the chunk to mapChan. If any errors occur,
 // the error is sent to errChan and processChunks returns immediately.
-func processChunks(chunkChan chan []byte, mapChan chan map[string]*TempInfo, errChan chan error, wg *sync.WaitGroup) {
+func processChunks(p *parser, chunkChan chan inputChunk, mapChan chan map[string]*TempInfo, errChan chan error, wg *sync.WaitGroup) {
 	defer func() {
 		wg.Done()
 	}()
 
 	for chunk := range chunkChan {
-		m, err := processChunk(chunk)
+		m, err := p.processChunk(chunk.data, chunk.offset)
+		chunk.done()
 		if err != nil {
 			errChan <- err
 			return
@@ -178,7 +769,35 @@ func processChunks(chunkChan chan []byte, mapChan chan map[string]*TempInfo, err
 }
 
 // processFile reads the file and produces a resulting map for the entire file.
-func processFile(r io.Reader, chunkSize int) (map[string]*TempInfo, error) {
+// If opts is nil the default options are used.
+func processFile(r io.Reader, chunkSize int, opts *Options) (map[string]*TempInfo, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	tempMap := make(map[string]*TempInfo, maxCities)
+	err := aggregateFile(r, chunkSize, opts, func(m map[string]*TempInfo) error {
+		mergeMap(tempMap, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tempMap, checkStationCount(tempMap, opts)
+}
+
+// aggregateFile reads the file and calls merge with the resulting map for each
+// chunk of the file. merge is called from a single goroutine.
+func aggregateFile(r io.Reader, chunkSize int, opts *Options, merge func(map[string]*TempInfo) error) error {
+	return aggregateChunks(opts, func(chunkChan chan inputChunk, errChan chan error) {
+		readChunks(r, chunkSize, newParser(opts), chunkChan, errChan)
+	}, merge)
+}
+
+// aggregateChunks processes the chunks sent by read and calls merge with the
+// resulting map for each chunk. read must close chunkChan when done. merge is
+// called from a single goroutine.
+func aggregateChunks(opts *Options, read func(chunkChan chan inputChunk, errChan chan error), merge func(map[string]*TempInfo) error) error {
 	// Create 1 goroutine per CPU core.
 	// 1: read chunks from file and send to chunkChan
 	// N-2: read chunks from chunkChan, process and send result to mapChan
@@ -186,16 +805,16 @@ func processFile(r io.Reader, chunkSize int) (map[string]*TempInfo, error) {
 
 	processGoroutines := runtime.NumCPU()
 
-	chunkChan := make(chan []byte, processGoroutines*3)
+	chunkChan := make(chan inputChunk, processGoroutines*3)
 	mapChan := make(chan map[string]*TempInfo, processGoroutines*2)
-	errChan := make(chan error, processGoroutines)
+	errChan := make(chan error, processGoroutines+1)
 
-	go readChunks(r, chunkSize, chunkChan, errChan)
+	go read(chunkChan, errChan)
 
 	var wg sync.WaitGroup
 	for i := 0; i < processGoroutines; i++ {
 		wg.Add(1)
-		go processChunks(chunkChan, mapChan, errChan, &wg)
+		go processChunks(newParser(opts), chunkChan, mapChan, errChan, &wg)
 	}
 
 	// Wait until all goroutines are finished and close the map channel.
@@ -205,37 +824,34 @@ func processFile(r io.Reader, chunkSize int) (map[string]*TempInfo, error) {
 	}()
 
 	// Merge resulting maps
-	tempMap := make(map[string]*TempInfo, maxCities)
+	var mergeErr error
 	for m := range mapChan {
-		mergeMap(tempMap, m)
+		if mergeErr == nil {
+			mergeErr = merge(m)
+		}
 	}
 
 	// Return an error if there is one.
 	select {
 	case err := <-errChan:
-		return nil, err
+		return err
 	default:
-		return tempMap, nil
+		return mergeErr
 	}
 }
 
-// readChunk reads and returns two chunks of input totaling the given size.
-// The first chunk contains full lines that can be processed. The second chunk is
-// the remainder which is a partial line. This is done to avoid copies.
-func readChunk(r io.Reader, size int) ([]byte, []byte, error) {
-	buf := make([]byte, size)          // lines of input
-	remainder := make([]byte, 0, size) // remaining bytes
+// readChunk reads into buf and returns two chunks of the input read. The first
+// chunk contains full lines that can be processed. The second chunk is the
+// remainder which is a partial line. Both are slices of buf to avoid copies.
+func readChunk(r io.Reader, buf []byte) ([]byte, []byte, error) {
 	bytesRead, err := r.Read(buf)
 	if err != nil && !errors.Is(err, io.EOF) {
-		return buf, remainder, err
+		return buf[:0], buf[:0], err
 	}
 	buf = buf[:bytesRead]
 
 	i := bytes.LastIndexByte(buf, '\n')
-	remainder = buf[i+1 : bytesRead]
-	buf = buf[:i+1]
-
-	return buf, remainder, err
+	return buf[:i+1], buf[i+1:], err
 }
 
 // fixRemainder creates a new bytes slice for the first line from a remainder
@@ -267,6 +883,16 @@ func fixRemainder(remainder, chunk []byte) ([]byte, []byte) {
 
 // processChunk reads an input chunk. Chunks should be comprised of full lines.
 func processChunk(b []byte) (map[string]*TempInfo, error) {
+	return processChunkKeys(b, nil)
+}
+
+// processChunkKeys reads an input chunk like processChunk. If key is not nil it
+// is called with the first value for each station name in the chunk and
+// returns the entry the station's values are added to, with the first value
+// added, or nil if the station's values are skipped. The returned map then
+// holds the entry for each station name, which may be nil or shared with
+// other names.
+func processChunkKeys(b []byte, key func(name string, num int) *TempInfo) (map[string]*TempInfo, error) {
 	m := make(map[string]*TempInfo, maxCities)
 	if len(b) == 0 {
 		return m, nil
@@ -298,17 +924,30 @@ func processChunk(b []byte) (map[string]*TempInfo, error) {
 				if len(c[j:i]) == 0 {
 					return nil, fmt.Errorf("%w: unexpected end of input", errInputFormat)
 				}
-				num := toInt(c[j:i])
+				num, ok := toInt(c[j:i])
+				if !ok {
+					// The number doesn't have exactly one fractional digit
+					// or is invalid.
+					n, err := parseDecimal(c[j:i], '.', 1)
+					if err != nil {
+						return nil, err
+					}
+					num = n
+				}
 
 				if info, ok := m[name]; ok {
-					if num < info.Min {
-						info.Min = num
-					}
-					info.Sum += num
-					if num > info.Max {
-						info.Max = num
+					if info != nil {
+						if num < info.Min {
+							info.Min = num
+						}
+						info.Sum += num
+						if num > info.Max {
+							info.Max = num
+						}
+						info.Count++
 					}
-					info.Count++
+				} else if key != nil {
+					m[name] = key(name, num)
 				} else {
 					m[name] = &TempInfo{
 						Min:   num,
@@ -333,41 +972,91 @@ func processChunk(b []byte) (map[string]*TempInfo, error) {
 // mergeMap merges the right map into the left map.
 func mergeMap(left, right map[string]*TempInfo) {
 	for k := range right {
-		rInfo := right[k]
 		if lInfo, ok := left[k]; ok {
-			if rInfo.Min < lInfo.Min {
-				lInfo.Min = rInfo.Min
-			}
-			if rInfo.Max > lInfo.Max {
-				lInfo.Max = rInfo.Max
-			}
-			lInfo.Sum += rInfo.Sum
-			lInfo.Count += rInfo.Count
+			lInfo.merge(right[k])
 		} else {
 			left[k] = right[k]
 		}
 	}
 }
 
+// merge merges the stats in o into t.
+func (t *TempInfo) merge(o *TempInfo) {
+	switch {
+	case o.Count == 0:
+	case t.Count == 0:
+		t.Min, t.Max = o.Min, o.Max
+	default:
+		if o.Min < t.Min {
+			t.Min = o.Min
+		}
+		if o.Max > t.Max {
+			t.Max = o.Max
+		}
+	}
+	t.Sum += o.Sum
+	t.Count += o.Count
+
+	if len(o.Extra) > 0 {
+		if n := len(o.Extra) - len(t.Extra); n > 0 {
+			t.Extra = append(t.Extra, make([]TempInfo, n)...)
+		}
+		for i := range o.Extra {
+			t.Extra[i].merge(&o.Extra[i])
+		}
+	}
+}
+
+// add adds the value num to the stats.
+func (t *TempInfo) add(num int) {
+	if t.Count == 0 || num < t.Min {
+		t.Min = num
+	}
+	if t.Count == 0 || num > t.Max {
+		t.Max = num
+	}
+	t.Sum += num
+	t.Count++
+}
+
+// clone returns a copy of t that shares no memory with t.
+func (t *TempInfo) clone() *TempInfo {
+	c := *t
+	if t.Extra != nil {
+		c.Extra = append([]TempInfo(nil), t.Extra...)
+	}
+	return &c
+}
+
 // toInt converts a string representation of a floating point number to the
-// nearest tenth (0.0) to an integer value.
-func toInt(s string) int {
+// nearest tenth (0.0) to an integer value. ok is false if the number is not
+// valid or doesn't have exactly one fractional digit. See parseDecimal for
+// other numbers.
+func toInt(s string) (int, bool) {
 	var isNegative bool
-	if s[0] == '-' {
+	if len(s) > 0 && s[0] == '-' {
 		isNegative = true
 		s = s[1:]
 	}
+	if len(s) < 3 || s[len(s)-2] != '.' {
+		return 0, false
+	}
 
 	var n int
 	for i := range s {
-		if s[i] != '.' {
-			n *= 10
-			n += int(s[i] - '0')
+		if i == len(s)-2 {
+			continue
+		}
+		d := s[i] - '0'
+		if d > 9 {
+			return 0, false
 		}
+		n *= 10
+		n += int(d)
 	}
 
 	if isNegative {
 		n *= -1
 	}
-	return n
+	return n, true
 }
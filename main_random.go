@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -11,45 +12,81 @@ const (
 	segmentSize = 2 * 1024 * 1024 // 2mb
 )
 
+// alignSegment returns the bounds of the full lines that start within the
+// segment of data of the given size starting at offset. Lines that span
+// segments belong to the segment containing the start of the line. ok is
+// false if no line starts within the segment.
+func alignSegment(data []byte, offset, size int64, opts *Options) (start, end int64, ok bool, err error) {
+	end = offset + size
+	fileLength := int64(len(data))
+	if offset >= fileLength {
+		return 0, 0, false, nil
+	}
+	if end > fileLength {
+		end = fileLength
+	}
+
+	if offset != 0 && data[offset-1] != '\n' {
+		// Start after the first newline.
+		nlOffset := int64(bytes.IndexByte(data[offset:end], '\n'))
+		if nlOffset < 0 {
+			// The segment is entirely within a line that started in
+			// a previous segment.
+			return 0, 0, false, nil
+		}
+		offset += nlOffset + 1
+	}
+	if data[end-1] != '\n' {
+		// Process the partial line at the end.
+		lineStart := offset + int64(bytes.LastIndexByte(data[offset:end], '\n')) + 1
+		nlOffset := int64(bytes.IndexByte(data[end:], '\n'))
+		if nlOffset < 0 {
+			end = fileLength
+		} else {
+			end += nlOffset
+		}
+		if opts.MaxLineLength > 0 && end-lineStart > int64(opts.MaxLineLength) {
+			return 0, 0, false, errLineTooLong(lineStart, opts.MaxLineLength)
+		}
+		if nlOffset >= 0 {
+			end++
+		}
+	}
+	return offset, end, true, nil
+}
+
 // processChunksRandom reads chunks, processes each line in the chunk,
-// and sends the resulting map for the chunk to mapChan. If any errors occur,
-// the error is sent to errChan and processChunks returns immediately.
-func processChunksRandom(data []byte, cursor *atomic.Int64, size int64, mapChan chan map[string]*TempInfo, errChan chan error, wg *sync.WaitGroup) {
+// and sends the resulting map for the chunk to mapChan. data starts at byte
+// offset base in the input. If any errors occur, the error is sent to errChan
+// and processChunks returns immediately.
+func processChunksRandom(data []byte, base int64, cursor *atomic.Int64, size int64, p *parser, mapChan chan map[string]*TempInfo, errChan chan error, wg *sync.WaitGroup) {
 	defer func() {
 		wg.Done()
 	}()
 
 	for {
 		offset := cursor.Add(size) - size
-		end := offset + size
-		fileLength := int64(len(data))
-		if offset >= fileLength {
+		if offset >= int64(len(data)) {
 			return
 		}
-		if end > fileLength {
-			end = fileLength
-		}
-
-		if offset != 0 && data[offset-1] != '\n' {
-			// Start after the first newline.
-			nlOffset := int64(bytes.IndexByte(data[offset:end], '\n'))
-			if nlOffset < 0 {
-				return
+		start, end, ok, err := alignSegment(data, offset, size, p.opts)
+		if err != nil {
+			var perr *ParseError
+			if errors.As(err, &perr) {
+				perr.Offset += base
 			}
-			offset += nlOffset + 1
+			errChan <- err
+			return
 		}
-		if data[end-1] != '\n' {
-			// Process the partial line at the end.
-			nlOffset := int64(bytes.IndexByte(data[end:], '\n'))
-			if nlOffset < 0 {
-				end = fileLength
-			} else {
-				end += nlOffset + 1
-			}
-
+		if !ok {
+			continue
 		}
 
-		m, err := processChunk(data[offset:end])
+		chunk := data[start:end]
+		if base+start == 0 {
+			chunk = p.trimPreamble(chunk)
+		}
+		m, err := p.processChunk(chunk, base+end-int64(len(chunk)))
 		if err != nil {
 			errChan <- err
 			return
@@ -59,17 +96,12 @@ func processChunksRandom(data []byte, cursor *atomic.Int64, size int64, mapChan
 }
 
 // processFileRandom reads the file at path in segments of size and produces a
-// resulting map for the entire file.
-func processFileRandom(path string, size int) (map[string]*TempInfo, error) {
-	// Create 1 goroutine per CPU core.
-	// 1: read chunks from file and send to chunkChan
-	// N-2: read chunks from chunkChan, process and send result to mapChan
-	// main: read results from mapChan and merge.
-	processGoroutines := runtime.NumCPU()
-	var cursor atomic.Int64
-
-	mapChan := make(chan map[string]*TempInfo, processGoroutines*2)
-	errChan := make(chan error, processGoroutines)
+// resulting map for the entire file. If opts is nil the default options are
+// used.
+func processFileRandom(path string, size int, opts *Options) (map[string]*TempInfo, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
 
 	f, data, err := mmapFile(path)
 	if err != nil {
@@ -78,10 +110,45 @@ func processFileRandom(path string, size int) (map[string]*TempInfo, error) {
 	defer f.Close()
 	defer munmap(data)
 
+	return processDataRandom(data, 0, size, opts)
+}
+
+// processDataRandom reads data, which starts at byte offset base in the input,
+// in segments of size and produces a resulting map for the data.
+func processDataRandom(data []byte, base int64, size int, opts *Options) (map[string]*TempInfo, error) {
+	parsers := make([]*parser, runtime.GOMAXPROCS(0))
+	for i := range parsers {
+		parsers[i] = newParser(opts)
+	}
+
+	tempMap := make(map[string]*TempInfo, maxCities)
+	err := aggregateDataRandom(data, base, size, parsers, func(m map[string]*TempInfo) error {
+		mergeMap(tempMap, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tempMap, checkStationCount(tempMap, opts)
+}
+
+// aggregateDataRandom reads data, which starts at byte offset base in the
+// input, in segments of size and calls merge with the resulting map for each
+// segment. One goroutine is started for each parser. merge is called from a
+// single goroutine.
+func aggregateDataRandom(data []byte, base int64, size int, parsers []*parser, merge func(map[string]*TempInfo) error) error {
+	// Create 1 goroutine per parser.
+	// N: read segments from data, process and send result to mapChan
+	// main: read results from mapChan and merge.
+	var cursor atomic.Int64
+
+	mapChan := make(chan map[string]*TempInfo, len(parsers)*2)
+	errChan := make(chan error, len(parsers))
+
 	var wg sync.WaitGroup
-	for i := 0; i < processGoroutines; i++ {
+	for _, p := range parsers {
 		wg.Add(1)
-		go processChunksRandom(data, &cursor, int64(size), mapChan, errChan, &wg)
+		go processChunksRandom(data, base, &cursor, int64(size), p, mapChan, errChan, &wg)
 	}
 
 	// Wait until all goroutines are finished and close the map channel.
@@ -91,16 +158,75 @@ func processFileRandom(path string, size int) (map[string]*TempInfo, error) {
 	}()
 
 	// Merge resulting maps
-	tempMap := make(map[string]*TempInfo, maxCities)
+	var mergeErr error
 	for m := range mapChan {
-		mergeMap(tempMap, m)
+		if mergeErr == nil {
+			mergeErr = merge(m)
+		}
 	}
 
 	// Return an error if there is one.
 	select {
 	case err := <-errChan:
-		return nil, err
+		return err
+	default:
+		return mergeErr
+	}
+}
+
+// scanDataRandom reads data, which starts at byte offset 0 in the input, in
+// segments of size and calls fn with each segment of full lines and its
+// offset. One goroutine is started for each parser and fn is called
+// concurrently with the index of the goroutine's parser. The first error
+// returned by fn stops all goroutines and is returned.
+func scanDataRandom(data []byte, size int, parsers []*parser, fn func(i int, chunk []byte, offset int64) error) error {
+	var cursor atomic.Int64
+	next := func() (int64, bool) {
+		offset := cursor.Add(int64(size)) - int64(size)
+		return offset, offset < int64(len(data))
+	}
+	return scanSegments(data, size, parsers, next, fn)
+}
+
+// scanSegments is like scanDataRandom but only reads the segments starting at
+// the offsets returned by next, which returns false when there are no more
+// segments. next is called concurrently.
+func scanSegments(data []byte, size int, parsers []*parser, next func() (int64, bool), fn func(i int, chunk []byte, offset int64) error) error {
+	var failed atomic.Bool
+	errChan := make(chan error, len(parsers))
+
+	var wg sync.WaitGroup
+	for i, p := range parsers {
+		wg.Add(1)
+		go func(i int, p *parser) {
+			defer wg.Done()
+			for !failed.Load() {
+				offset, ok := next()
+				if !ok {
+					return
+				}
+				start, end, ok, err := alignSegment(data, offset, int64(size), p.opts)
+				if err == nil && ok {
+					chunk := data[start:end]
+					if start == 0 {
+						chunk = p.trimPreamble(chunk)
+					}
+					err = fn(i, chunk, end-int64(len(chunk)))
+				}
+				if err != nil {
+					failed.Store(true)
+					errChan <- err
+					return
+				}
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errChan:
+		return err
 	default:
-		return tempMap, nil
+		return nil
 	}
 }
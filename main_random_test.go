@@ -14,6 +14,7 @@ func Test_processFileRandom(t *testing.T) {
 	testCases := map[string]struct {
 		input    string
 		size     int
+		opts     *Options
 		expected map[string]*TempInfo
 		err      error
 	}{
@@ -65,6 +66,85 @@ func Test_processFileRandom(t *testing.T) {
 				},
 			},
 		},
+		"line longer than chunk": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\nbaz;3.0\n",
+			size:  4,
+			expected: map[string]*TempInfo{
+				"foo": {
+					Min:   10,
+					Max:   10,
+					Sum:   10,
+					Count: 1,
+				},
+				"barbazbarbaz": {
+					Min:   20,
+					Max:   20,
+					Sum:   20,
+					Count: 1,
+				},
+				"baz": {
+					Min:   30,
+					Max:   30,
+					Sum:   30,
+					Count: 1,
+				},
+			},
+		},
+		"line longer than chunk no final newline": {
+			input: "foo;1.0\nbarbazbarbaz;2.0",
+			size:  4,
+			expected: map[string]*TempInfo{
+				"foo": {
+					Min:   10,
+					Max:   10,
+					Sum:   10,
+					Count: 1,
+				},
+				"barbazbarbaz": {
+					Min:   20,
+					Max:   20,
+					Sum:   20,
+					Count: 1,
+				},
+			},
+		},
+		"line within max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\n",
+			size:  4,
+			opts:  &Options{MaxLineLength: 16},
+			expected: map[string]*TempInfo{
+				"foo": {
+					Min:   10,
+					Max:   10,
+					Sum:   10,
+					Count: 1,
+				},
+				"barbazbarbaz": {
+					Min:   20,
+					Max:   20,
+					Sum:   20,
+					Count: 1,
+				},
+			},
+		},
+		"line exceeds max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\nbaz;3.0\n",
+			size:  4,
+			opts:  &Options{MaxLineLength: 8},
+			err:   errInputFormat,
+		},
+		"line within chunk exceeds max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\nbaz;3.0\n",
+			size:  64,
+			opts:  &Options{MaxLineLength: 8},
+			err:   errInputFormat,
+		},
+		"last line exceeds max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0",
+			size:  64,
+			opts:  &Options{MaxLineLength: 8},
+			err:   errInputFormat,
+		},
 	}
 
 	for name, tc := range testCases {
@@ -83,7 +163,7 @@ func Test_processFileRandom(t *testing.T) {
 			f.Close()
 			// defer os.Remove(f.Name())
 
-			m, err := processFileRandom(f.Name(), tc.size)
+			m, err := processFileRandom(f.Name(), tc.size, tc.opts)
 			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
 				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
 			}
@@ -96,6 +176,6 @@ func Test_processFileRandom(t *testing.T) {
 
 func Benchmark_processFileRandom(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		_, _ = processFileRandom("test/measurements-10000-unique-keys.txt", segmentSize)
+		_, _ = processFileRandom("test/measurements-10000-unique-keys.txt", segmentSize, nil)
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -53,7 +54,7 @@ func Test_readChunk(t *testing.T) {
 
 			r := strings.NewReader(tc.input)
 
-			buf, remainder, err := readChunk(r, tc.size)
+			buf, remainder, err := readChunk(r, make([]byte, tc.size))
 			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
 				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
 			}
@@ -72,10 +73,11 @@ func Benchmark_readChunk(b *testing.B) {
 	if err != nil {
 		b.Fatalf("open: %v", err)
 	}
+	buf := make([]byte, chunkSize)
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _, _ = readChunk(f, chunkSize)
+		_, _, _ = readChunk(f, buf)
 		b.StopTimer()
 		f.Seek(0, os.SEEK_SET)
 		b.StartTimer()
@@ -157,6 +159,32 @@ func Test_processChunk(t *testing.T) {
 				},
 			},
 		},
+		"no fractional digit": {
+			chunk: []byte("Halifax;3\n"),
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   30,
+					Max:   30,
+					Sum:   30,
+					Count: 1,
+				},
+			},
+		},
+		"two fractional digits": {
+			chunk: []byte("Halifax;1.25\nHalifax;-1.24\n"),
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -12,
+					Max:   13,
+					Sum:   1,
+					Count: 2,
+				},
+			},
+		},
+		"invalid number": {
+			chunk: []byte("Halifax;+5\n"),
+			err:   errInputFormat,
+		},
 		"no semicolon": {
 			chunk: []byte("Halifax\n"),
 			err:   errInputFormat,
@@ -347,6 +375,41 @@ func Test_mergeMap(t *testing.T) {
 				},
 			},
 		},
+		"merge columns": {
+			left: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   20,
+					Sum:   40,
+					Count: 3,
+					Extra: []TempInfo{{}},
+				},
+			},
+			right: map[string]*TempInfo{
+				"Halifax": {
+					Min:   20,
+					Max:   30,
+					Sum:   50,
+					Count: 2,
+					Extra: []TempInfo{
+						{Min: -50, Max: -10, Sum: -60, Count: 2},
+						{Min: 400, Max: 400, Sum: 400, Count: 1},
+					},
+				},
+			},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   90,
+					Count: 5,
+					Extra: []TempInfo{
+						{Min: -50, Max: -10, Sum: -60, Count: 2},
+						{Min: 400, Max: 400, Sum: 400, Count: 1},
+					},
+				},
+			},
+		},
 	}
 
 	for name, tc := range testCases {
@@ -408,6 +471,7 @@ func Test_processFile(t *testing.T) {
 	testCases := map[string]struct {
 		input    string
 		size     int
+		opts     *Options
 		expected map[string]*TempInfo
 		err      error
 	}{
@@ -459,6 +523,85 @@ func Test_processFile(t *testing.T) {
 				},
 			},
 		},
+		"line longer than chunk": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\nbaz;3.0\n",
+			size:  4,
+			expected: map[string]*TempInfo{
+				"foo": {
+					Min:   10,
+					Max:   10,
+					Sum:   10,
+					Count: 1,
+				},
+				"barbazbarbaz": {
+					Min:   20,
+					Max:   20,
+					Sum:   20,
+					Count: 1,
+				},
+				"baz": {
+					Min:   30,
+					Max:   30,
+					Sum:   30,
+					Count: 1,
+				},
+			},
+		},
+		"line longer than chunk no final newline": {
+			input: "foo;1.0\nbarbazbarbaz;2.0",
+			size:  4,
+			expected: map[string]*TempInfo{
+				"foo": {
+					Min:   10,
+					Max:   10,
+					Sum:   10,
+					Count: 1,
+				},
+				"barbazbarbaz": {
+					Min:   20,
+					Max:   20,
+					Sum:   20,
+					Count: 1,
+				},
+			},
+		},
+		"line within max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\n",
+			size:  4,
+			opts:  &Options{MaxLineLength: 16},
+			expected: map[string]*TempInfo{
+				"foo": {
+					Min:   10,
+					Max:   10,
+					Sum:   10,
+					Count: 1,
+				},
+				"barbazbarbaz": {
+					Min:   20,
+					Max:   20,
+					Sum:   20,
+					Count: 1,
+				},
+			},
+		},
+		"line exceeds max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\nbaz;3.0\n",
+			size:  4,
+			opts:  &Options{MaxLineLength: 8},
+			err:   errInputFormat,
+		},
+		"line within chunk exceeds max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0\nbaz;3.0\n",
+			size:  64,
+			opts:  &Options{MaxLineLength: 8},
+			err:   errInputFormat,
+		},
+		"last line exceeds max length": {
+			input: "foo;1.0\nbarbazbarbaz;2.0",
+			size:  64,
+			opts:  &Options{MaxLineLength: 8},
+			err:   errInputFormat,
+		},
 	}
 
 	for name, tc := range testCases {
@@ -468,7 +611,7 @@ func Test_processFile(t *testing.T) {
 
 			r := strings.NewReader(tc.input)
 
-			m, err := processFile(r, tc.size)
+			m, err := processFile(r, tc.size, tc.opts)
 			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
 				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
 			}
@@ -487,7 +630,7 @@ func Benchmark_processFile(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _ = processFile(f, chunkSize)
+		_, _ = processFile(f, chunkSize, nil)
 		b.StopTimer()
 		f.Seek(0, os.SEEK_SET)
 		b.StartTimer()
@@ -498,20 +641,44 @@ func Test_toInt(t *testing.T) {
 	t.Parallel()
 
 	testCases := map[string]struct {
-		s string
-		n int
+		s  string
+		n  int
+		ok bool
 	}{
 		"zero decimal": {
-			s: "5.0",
-			n: 50,
+			s:  "5.0",
+			n:  50,
+			ok: true,
 		},
 		"greater than 10": {
-			s: "15.2",
-			n: 152,
+			s:  "15.2",
+			n:  152,
+			ok: true,
 		},
 		"less than 10": {
-			s: "4.6",
-			n: 46,
+			s:  "4.6",
+			n:  46,
+			ok: true,
+		},
+		"negative": {
+			s:  "-4.6",
+			n:  -46,
+			ok: true,
+		},
+		"two fractional digits": {
+			s: "1.25",
+		},
+		"no fractional digits": {
+			s: "3",
+		},
+		"multiple decimal points": {
+			s: "1.2.3",
+		},
+		"plus sign": {
+			s: "+5.0",
+		},
+		"no integer digits": {
+			s: "-.5",
 		},
 	}
 
@@ -520,10 +687,331 @@ func Test_toInt(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			n := toInt(tc.s)
+			n, ok := toInt(tc.s)
+			if diff := cmp.Diff(tc.ok, ok); diff != "" {
+				t.Fatalf("unexpected ok (-want, +got):\n%s", diff)
+			}
 			if diff := cmp.Diff(tc.n, n); diff != "" {
 				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
 			}
 		})
 	}
 }
+
+func Test_printMap(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		m        map[string]*TempInfo
+		opts     *Options
+		expected string
+	}{
+		"empty": {
+			m:        map[string]*TempInfo{},
+			opts:     &Options{},
+			expected: "{}\n",
+		},
+		"sorted": {
+			m: map[string]*TempInfo{
+				"Zagreb": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 3,
+				},
+			},
+			opts:     &Options{},
+			expected: "{Halifax=1.0/1.3/3.0, Zagreb=-3.1/4.9/12.9}\n",
+		},
+		"scale": {
+			m: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -5,
+					Max:   125,
+					Sum:   120,
+					Count: 3,
+				},
+			},
+			opts:     &Options{Scale: 2},
+			expected: "{Halifax=-0.05/0.40/1.25}\n",
+		},
+		"json": {
+			m: map[string]*TempInfo{
+				"Zagreb": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 3,
+				},
+			},
+			opts: &Options{Format: formatJSON},
+			expected: `[{"name":"Halifax","min":1.0,"mean":1.3,"max":3.0,"count":3},` +
+				`{"name":"Zagreb","min":-3.1,"mean":4.9,"max":12.9,"count":2}]` + "\n",
+		},
+		"json empty": {
+			m:        map[string]*TempInfo{},
+			opts:     &Options{Format: formatJSON},
+			expected: "[]\n",
+		},
+		"csv": {
+			m: map[string]*TempInfo{
+				"Zagreb": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"St. John's, NL": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 3,
+				},
+			},
+			opts:     &Options{Format: formatCSV},
+			expected: "station,min,mean,max,count\n\"St. John's, NL\",1.0,1.3,3.0,3\nZagreb,-3.1,4.9,12.9,2\n",
+		},
+		"windowed": {
+			m: map[string]*TempInfo{
+				"2026-10-17T13:00:00ZHalifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 3,
+				},
+				"2026-10-17T12:00:00ZZagreb": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+			},
+			opts:     &Options{Window: time.Hour},
+			expected: "{2026-10-17T12:00:00Z Zagreb=-3.1/4.9/12.9, 2026-10-17T13:00:00Z Halifax=1.0/1.3/3.0}\n",
+		},
+		"windowed json": {
+			m: map[string]*TempInfo{
+				"2026-10-17T12:00:00ZZagreb": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+			},
+			opts:     &Options{Window: time.Hour, Format: formatJSON},
+			expected: `[{"window":"2026-10-17T12:00:00Z","name":"Zagreb","min":-3.1,"mean":4.9,"max":12.9,"count":2}]` + "\n",
+		},
+		"windowed csv": {
+			m: map[string]*TempInfo{
+				"2026-10-17T12:00:00ZZagreb": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+			},
+			opts:     &Options{Window: time.Hour, Format: formatCSV},
+			expected: "window,station,min,mean,max,count\n2026-10-17T12:00:00Z,Zagreb,-3.1,4.9,12.9,2\n",
+		},
+		"csv empty": {
+			m:        map[string]*TempInfo{},
+			opts:     &Options{Format: formatCSV},
+			expected: "station,min,mean,max,count\n",
+		},
+		"columns": {
+			m: map[string]*TempInfo{
+				"Zagreb": {
+					Min:   14,
+					Max:   122,
+					Sum:   136,
+					Count: 2,
+					Extra: []TempInfo{
+						{},
+						{Min: 10135, Max: 10135, Sum: 10135, Count: 1},
+					},
+				},
+			},
+			opts:     &Options{Columns: []string{"temperature", "humidity", "pressure"}},
+			expected: "{Zagreb=1.4/6.8/12.2;;1013.5/1013.5/1013.5}\n",
+		},
+		"columns json": {
+			m: map[string]*TempInfo{
+				"Zagreb": {
+					Min:   14,
+					Max:   122,
+					Sum:   136,
+					Count: 2,
+					Extra: []TempInfo{
+						{},
+						{Min: 10135, Max: 10135, Sum: 10135, Count: 1},
+					},
+				},
+			},
+			opts: &Options{Columns: []string{"temperature", "humidity", "pressure"}, Format: formatJSON},
+			expected: `[{"name":"Zagreb","min":1.4,"mean":6.8,"max":12.2,"count":2,"columns":[` +
+				`{"name":"temperature","min":1.4,"mean":6.8,"max":12.2,"count":2},` +
+				`{"name":"humidity","count":0},` +
+				`{"name":"pressure","min":1013.5,"mean":1013.5,"max":1013.5,"count":1}]}]` + "\n",
+		},
+		"columns csv": {
+			m: map[string]*TempInfo{
+				"Zagreb": {
+					Min:   14,
+					Max:   122,
+					Sum:   136,
+					Count: 2,
+					Extra: []TempInfo{
+						{},
+						{Min: 10135, Max: 10135, Sum: 10135, Count: 1},
+					},
+				},
+			},
+			opts: &Options{Columns: []string{"temperature", "humidity", "pressure"}, Format: formatCSV},
+			expected: "station,temperature_min,temperature_mean,temperature_max,temperature_count," +
+				"humidity_min,humidity_mean,humidity_max,humidity_count," +
+				"pressure_min,pressure_mean,pressure_max,pressure_count\n" +
+				"Zagreb,1.4,6.8,12.2,2,,,,0,1013.5,1013.5,1013.5,1\n",
+		},
+		"fahrenheit": {
+			m: map[string]*TempInfo{
+				"Bosaso": {
+					Min:   -150,
+					Max:   200,
+					Sum:   50,
+					Count: 4,
+				},
+			},
+			opts:     &Options{Unit: unitFahrenheit},
+			expected: "{Bosaso=5.0/34.3/68.0}\n",
+		},
+		"kelvin json": {
+			m: map[string]*TempInfo{
+				"Bosaso": {
+					Min:   -150,
+					Max:   200,
+					Sum:   50,
+					Count: 4,
+				},
+			},
+			opts:     &Options{Unit: unitKelvin, Format: formatJSON},
+			expected: `[{"name":"Bosaso","min":258.2,"mean":274.4,"max":293.2,"count":4}]` + "\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := printMap(&b, tc.m, tc.opts); err != nil {
+				t.Fatalf("printMap: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, b.String()); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_headerColumns(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path     string
+		opts     *Options
+		expected []string
+	}{
+		"columns": {
+			path:     "test/options/measurements-columns.txt",
+			opts:     &Options{Header: true},
+			expected: []string{"temperature", "humidity", "pressure"},
+		},
+		"single column": {
+			path: "test/options/measurements-european.txt",
+			opts: &Options{Decimal: ',', Header: true},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := headerColumns(tc.path, tc.opts)
+			if err != nil {
+				t.Fatalf("headerColumns: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_checkModeFlags(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		cmd  string
+		set  map[string]bool
+		fail bool
+	}{
+		"input file": {
+			cmd: "measurements.txt",
+			set: map[string]bool{"procs": true, "partial-out": true},
+		},
+		"parse flags": {
+			cmd: "coordinator",
+			set: map[string]bool{"scale": true, "format": true},
+		},
+		"supported": {
+			cmd: "merge",
+			set: map[string]bool{"partial-out": true, "partial-format": true},
+		},
+		"procs": {
+			cmd:  "coordinator",
+			set:  map[string]bool{"procs": true},
+			fail: true,
+		},
+		"partial out": {
+			cmd:  "diff",
+			set:  map[string]bool{"partial-out": true},
+			fail: true,
+		},
+		"memory budget": {
+			cmd:  "snapshot",
+			set:  map[string]bool{"memory-budget": true},
+			fail: true,
+		},
+		"follow": {
+			cmd:  "serve",
+			set:  map[string]bool{"follow": true},
+			fail: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkModeFlags(tc.cmd, tc.set)
+			if got := err != nil; got != tc.fail {
+				t.Fatalf("checkModeFlags(%q, %v): want error %v, got %v", tc.cmd, tc.set, tc.fail, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxMissingReported is the maximum number of station names reported as
+// missing from the metadata.
+const maxMissingReported = 20
+
+// Metadata holds reference data for stations, such as their country and
+// location.
+type Metadata struct {
+	// Columns names the metadata columns.
+	Columns []string
+
+	// Stations maps station names to their values for each column.
+	Stations map[string][]string
+
+	// mu guards missing.
+	mu sync.Mutex
+
+	// missing holds the names of stations seen while parsing that have no
+	// metadata.
+	missing map[string]struct{}
+}
+
+// column returns the index of the named column or -1 if there is no such
+// column.
+func (md *Metadata) column(name string) int {
+	return slices.Index(md.Columns, name)
+}
+
+// readMetadata reads station metadata from the CSV file at path. The file
+// must have a header row naming its columns. The first column is the station
+// name.
+func readMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: missing header", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("%s: no metadata columns", path)
+	}
+
+	md := &Metadata{
+		Columns:  header[1:],
+		Stations: make(map[string][]string),
+	}
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return md, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, ok := md.Stations[rec[0]]; ok {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: duplicate station %q", path, line, rec[0])
+		}
+		md.Stations[rec[0]] = rec[1:]
+	}
+}
+
+// parseWhere parses a comma separated list of column=value conditions. An
+// empty string is no conditions.
+func parseWhere(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	where := make(map[string]string)
+	for _, cond := range strings.Split(s, ",") {
+		col, value, ok := strings.Cut(cond, "=")
+		if !ok || col == "" {
+			return nil, fmt.Errorf("invalid condition %q", cond)
+		}
+		where[col] = value
+	}
+	return where, nil
+}
+
+// stationMetadata returns the metadata values for the station name keyed by
+// column or nil if the station has no metadata. Metadata is not attached to
+// results if stations are grouped.
+func stationMetadata(name string, opts *Options) map[string]string {
+	if opts.Metadata == nil || opts.grouped() {
+		return nil
+	}
+	values, ok := opts.Metadata.Stations[name]
+	if !ok {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for i, v := range values {
+		m[opts.Metadata.Columns[i]] = v
+	}
+	return m
+}
+
+// addMissing records the station name as missing if it has no metadata. It
+// may be called concurrently.
+func (md *Metadata) addMissing(name string) {
+	if _, ok := md.Stations[name]; ok {
+		return
+	}
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	if md.missing == nil {
+		md.missing = make(map[string]struct{})
+	}
+	md.missing[name] = struct{}{}
+}
+
+// missingMetadata returns the sorted names of stations missing from the
+// metadata. Names are recorded by the parsers before stations are grouped,
+// so group names are never reported. If stations are not grouped, result keys
+// in m are also checked, which covers results that were merged rather than
+// parsed.
+func missingMetadata(m map[string]*TempInfo, opts *Options) []string {
+	md := opts.Metadata
+	if md == nil {
+		return nil
+	}
+	if !opts.grouped() {
+		for key := range m {
+			name := key
+			if opts.Window > 0 {
+				_, name = splitWindowKey(key)
+			}
+			md.addMissing(name)
+		}
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	var missing []string
+	for name := range md.missing {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// summarizeNames returns a comma separated list of at most n of the given
+// names.
+func summarizeNames(names []string, n int) string {
+	if len(names) <= n {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:n], ", "), len(names)-n)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_readMetadata(t *testing.T) {
+	t.Parallel()
+
+	md, err := readMetadata("test/options/metadata.csv")
+	if err != nil {
+		t.Fatalf("readMetadata: %v", err)
+	}
+	if diff := cmp.Diff([]string{"country", "latitude", "longitude", "elevation"}, md.Columns); diff != "" {
+		t.Fatalf("unexpected columns (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"CA", "44.65", "-63.57", "145"}, md.Stations["Halifax"]); diff != "" {
+		t.Fatalf("unexpected values (-want, +got):\n%s", diff)
+	}
+	if got := len(md.Stations); got != 8 {
+		t.Fatalf("want 8 stations, got %d", got)
+	}
+
+	for _, path := range []string{
+		"test/options/metadata-duplicate.csv",
+		"test/options/stations.txt",
+		"test/options/missing.csv",
+	} {
+		if _, err := readMetadata(path); err == nil {
+			t.Fatalf("readMetadata(%q): expected error", path)
+		}
+	}
+}
+
+func Test_parseWhere(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseWhere("country=NZ,elevation=4")
+	if err != nil {
+		t.Fatalf("parseWhere: %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"country": "NZ", "elevation": "4"}, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+	for _, s := range []string{"country", "=NZ"} {
+		if _, err := parseWhere(s); err == nil {
+			t.Fatalf("parseWhere(%q): expected error", s)
+		}
+	}
+}
+
+func Test_processFile_metadata(t *testing.T) {
+	t.Parallel()
+
+	// Missing stations are recorded in the metadata so each test case
+	// reads its own copy.
+	testCases := map[string]struct {
+		opts     *Options
+		expected map[string]*TempInfo
+		missing  []string
+	}{
+		"group by": {
+			opts: &Options{GroupBy: "country", Aliases: map[string]string{"Zagreb": "Halifax"}},
+			expected: map[string]*TempInfo{
+				"CA":     {Min: 122, Max: 129, Sum: 251, Count: 2},
+				"AU":     {Min: 150, Max: 150, Sum: 150, Count: 1},
+				"NZ":     {Min: 382, Max: 382, Sum: 382, Count: 1},
+				"US":     {Min: 97, Max: 97, Sum: 97, Count: 1},
+				"PK":     {Min: 154, Max: 154, Sum: 154, Count: 1},
+				"MX":     {Min: 149, Max: 149, Sum: 149, Count: 1},
+				"Ségou":  {Min: 257, Max: 257, Sum: 257, Count: 1},
+				"Xi'an":  {Min: 242, Max: 242, Sum: 242, Count: 1},
+				"Dodoma": {Min: 222, Max: 222, Sum: 222, Count: 1},
+			},
+			missing: []string{"Dodoma", "Ségou", "Xi'an"},
+		},
+		"where": {
+			opts: &Options{Where: map[string]string{"country": "NZ"}},
+			expected: map[string]*TempInfo{
+				"Tauranga": {Min: 382, Max: 382, Sum: 382, Count: 1},
+			},
+		},
+		"where group by": {
+			opts: &Options{Where: map[string]string{"elevation": "145"}, GroupBy: "country"},
+			expected: map[string]*TempInfo{
+				"CA": {Min: 129, Max: 129, Sum: 129, Count: 1},
+			},
+		},
+		"groups": {
+			// Group names are not reported but stations in groups
+			// are.
+			opts: &Options{Groups: map[string]string{"Halifax": "North", "Zagreb": "North", "Dodoma": "South", "Ségou": "South"}},
+			expected: map[string]*TempInfo{
+				"North":          {Min: 122, Max: 129, Sum: 251, Count: 2},
+				"South":          {Min: 222, Max: 257, Sum: 479, Count: 2},
+				"Cabo San Lucas": {Min: 149, Max: 149, Sum: 149, Count: 1},
+				"Adelaide":       {Min: 150, Max: 150, Sum: 150, Count: 1},
+				"Pittsburgh":     {Min: 97, Max: 97, Sum: 97, Count: 1},
+				"Karachi":        {Min: 154, Max: 154, Sum: 154, Count: 1},
+				"Xi'an":          {Min: 242, Max: 242, Sum: 242, Count: 1},
+				"Tauranga":       {Min: 382, Max: 382, Sum: 382, Count: 1},
+			},
+			missing: []string{"Dodoma", "Ségou", "Xi'an"},
+		},
+		"group match": {
+			opts: &Options{GroupMatch: "^[A-K]"},
+			expected: map[string]*TempInfo{
+				"H":          {Min: 129, Max: 129, Sum: 129, Count: 1},
+				"Zagreb":     {Min: 122, Max: 122, Sum: 122, Count: 1},
+				"C":          {Min: 149, Max: 149, Sum: 149, Count: 1},
+				"A":          {Min: 150, Max: 150, Sum: 150, Count: 1},
+				"Ségou":      {Min: 257, Max: 257, Sum: 257, Count: 1},
+				"Pittsburgh": {Min: 97, Max: 97, Sum: 97, Count: 1},
+				"K":          {Min: 154, Max: 154, Sum: 154, Count: 1},
+				"Xi'an":      {Min: 242, Max: 242, Sum: 242, Count: 1},
+				"D":          {Min: 222, Max: 222, Sum: 222, Count: 1},
+				"Tauranga":   {Min: 382, Max: 382, Sum: 382, Count: 1},
+			},
+			missing: []string{"Dodoma", "Ségou", "Xi'an"},
+		},
+	}
+
+	const path = "test/measurements-10.txt"
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			md, err := readMetadata("test/options/metadata.csv")
+			if err != nil {
+				t.Fatalf("readMetadata: %v", err)
+			}
+			tc.opts.Metadata = md
+			if err := tc.opts.validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer f.Close()
+
+			m, err := processFile(f, 16, tc.opts)
+			if err != nil {
+				t.Fatalf("processFile: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, m); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.missing, missingMetadata(m, tc.opts)); diff != "" {
+				t.Fatalf("unexpected missing stations (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_processFileSpill_metadata(t *testing.T) {
+	t.Parallel()
+
+	md, err := readMetadata("test/options/metadata.csv")
+	if err != nil {
+		t.Fatalf("readMetadata: %v", err)
+	}
+	f, err := os.Open("test/measurements-10.txt")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	// Results spilled to disk are not in a map but missing stations are
+	// still reported.
+	opts := &Options{Metadata: md, GroupBy: "country", MemoryBudget: 1}
+	sm, err := processFileSpill(f, opts)
+	if err != nil {
+		t.Fatalf("processFileSpill: %v", err)
+	}
+	defer sm.Close()
+	if diff := cmp.Diff([]string{"Dodoma", "Ségou", "Xi'an"}, missingMetadata(nil, opts)); diff != "" {
+		t.Fatalf("unexpected missing stations (-want, +got):\n%s", diff)
+	}
+}
+
+func Test_printMap_metadata(t *testing.T) {
+	t.Parallel()
+
+	md := &Metadata{
+		Columns: []string{"country", "elevation"},
+		Stations: map[string][]string{
+			"Halifax": {"CA", "145"},
+		},
+	}
+	m := map[string]*TempInfo{
+		"Halifax": {Min: 10, Max: 30, Sum: 40, Count: 3},
+		"Zagreb":  {Min: -31, Max: 129, Sum: 98, Count: 2},
+	}
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected string
+	}{
+		"1brc": {
+			opts:     &Options{Metadata: md},
+			expected: "{Halifax=1.0/1.3/3.0, Zagreb=-3.1/4.9/12.9}\n",
+		},
+		"json": {
+			opts: &Options{Metadata: md, Format: formatJSON},
+			expected: `[{"name":"Halifax","min":1.0,"mean":1.3,"max":3.0,"count":3,"metadata":{"country":"CA","elevation":"145"}},` +
+				`{"name":"Zagreb","min":-3.1,"mean":4.9,"max":12.9,"count":2}]` + "\n",
+		},
+		"csv": {
+			opts: &Options{Metadata: md, Format: formatCSV},
+			expected: "station,min,mean,max,count,country,elevation\n" +
+				"Halifax,1.0,1.3,3.0,3,CA,145\n" +
+				"Zagreb,-3.1,4.9,12.9,2,,\n",
+		},
+		"grouped": {
+			opts:     &Options{Metadata: md, GroupBy: "country", Format: formatCSV},
+			expected: "station,min,mean,max,count\nHalifax,1.0,1.3,3.0,3\nZagreb,-3.1,4.9,12.9,2\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := printMap(&b, m, tc.opts); err != nil {
+				t.Fatalf("printMap: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, b.String()); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
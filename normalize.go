@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms for station names.
+const (
+	normalizeNone = "none"
+	normalizeNFC  = "nfc"
+	normalizeNFKC = "nfkc"
+)
+
+// collateBytes orders station names by their bytes.
+const collateBytes = "bytes"
+
+// validNormalize returns true if form is a known normalization form.
+func validNormalize(form string) bool {
+	switch form {
+	case "", normalizeNone, normalizeNFC, normalizeNFKC:
+		return true
+	}
+	return false
+}
+
+// newNormalizer returns a function that normalizes station names according to
+// opts or nil if names are not normalized. The returned function may return
+// its argument and is not safe for concurrent use.
+func newNormalizer(opts *Options) func(name []byte) []byte {
+	var form norm.Form
+	switch opts.Normalize {
+	case normalizeNFC:
+		form = norm.NFC
+	case normalizeNFKC:
+		form = norm.NFKC
+	default:
+		if !opts.FoldCase {
+			return nil
+		}
+		return cases.Fold().Bytes
+	}
+	if !opts.FoldCase {
+		return form.Bytes
+	}
+
+	// Case folding may produce unnormalized text so normalize after
+	// folding.
+	fold := cases.Fold()
+	return func(name []byte) []byte {
+		return form.Bytes(fold.Bytes(form.Bytes(name)))
+	}
+}
+
+// parseCollation parses a collation given as "bytes" or a BCP 47 language tag.
+// An empty string is byte order.
+func parseCollation(s string) (language.Tag, bool, error) {
+	if s == "" || s == collateBytes {
+		return language.Und, false, nil
+	}
+	tag, err := language.Parse(s)
+	if err != nil {
+		return language.Und, false, fmt.Errorf("invalid collation %q: %w", s, err)
+	}
+	return tag, true, nil
+}
+
+// nameCompare returns a function that compares station names, or windowed
+// keys if results are windowed, in the collation given by opts.
+func nameCompare(opts *Options) func(a, b string) int {
+	tag, ok, err := parseCollation(opts.Collate)
+	if err != nil || !ok {
+		return strings.Compare
+	}
+	c := collate.New(tag)
+	compare := func(a, b string) int {
+		// Order names that collate equally by their bytes.
+		if r := c.CompareString(a, b); r != 0 {
+			return r
+		}
+		return strings.Compare(a, b)
+	}
+	if opts.Window == 0 {
+		return compare
+	}
+	return func(a, b string) int {
+		wa, na := splitWindowKey(a)
+		wb, nb := splitWindowKey(b)
+		if r := strings.Compare(wa, wb); r != 0 {
+			return r
+		}
+		return compare(na, nb)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_processFile_normalize(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected map[string]*TempInfo
+	}{
+		"none": {
+			opts: &Options{Normalize: normalizeNone},
+			expected: map[string]*TempInfo{
+				"Ségou":       {Min: 257, Max: 257, Sum: 257, Count: 1},
+				"Se\u0301gou": {Min: 201, Max: 201, Sum: 201, Count: 1},
+				"SÉGOU":       {Min: 100, Max: 100, Sum: 100, Count: 1},
+				"\ufb01ji":    {Min: 50, Max: 50, Sum: 50, Count: 1},
+				"fiji":        {Min: 70, Max: 70, Sum: 70, Count: 1},
+			},
+		},
+		"nfc": {
+			opts: &Options{Normalize: normalizeNFC},
+			expected: map[string]*TempInfo{
+				"Ségou":    {Min: 201, Max: 257, Sum: 458, Count: 2},
+				"SÉGOU":    {Min: 100, Max: 100, Sum: 100, Count: 1},
+				"\ufb01ji": {Min: 50, Max: 50, Sum: 50, Count: 1},
+				"fiji":     {Min: 70, Max: 70, Sum: 70, Count: 1},
+			},
+		},
+		"nfkc": {
+			opts: &Options{Normalize: normalizeNFKC},
+			expected: map[string]*TempInfo{
+				"Ségou": {Min: 201, Max: 257, Sum: 458, Count: 2},
+				"SÉGOU": {Min: 100, Max: 100, Sum: 100, Count: 1},
+				"fiji":  {Min: 50, Max: 70, Sum: 120, Count: 2},
+			},
+		},
+		"fold case": {
+			opts: &Options{FoldCase: true},
+			expected: map[string]*TempInfo{
+				"ségou":       {Min: 100, Max: 257, Sum: 357, Count: 2},
+				"se\u0301gou": {Min: 201, Max: 201, Sum: 201, Count: 1},
+				"fiji":        {Min: 50, Max: 70, Sum: 120, Count: 2},
+			},
+		},
+		"nfc fold case": {
+			opts: &Options{Normalize: normalizeNFC, FoldCase: true},
+			expected: map[string]*TempInfo{
+				"ségou": {Min: 100, Max: 257, Sum: 558, Count: 3},
+				"fiji":  {Min: 50, Max: 70, Sum: 120, Count: 2},
+			},
+		},
+		"filter normalized names": {
+			opts: &Options{Normalize: normalizeNFC, Stations: []string{"Ségou"}},
+			expected: map[string]*TempInfo{
+				"Ségou": {Min: 201, Max: 257, Sum: 458, Count: 2},
+			},
+		},
+	}
+
+	const path = "test/options/measurements-unicode.txt"
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Process the file with both the fast and default paths.
+			fast := *tc.opts
+			slow := *tc.opts
+			slow.SkipBlank = true
+			for _, opts := range []*Options{&fast, &slow} {
+				f, err := os.Open(path)
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				defer f.Close()
+
+				m, err := processFile(f, 16, opts)
+				if err != nil {
+					t.Fatalf("processFile: %v", err)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFile: unexpected result (-want, +got):\n%s", diff)
+				}
+
+				m, err = processFileRandom(path, 16, opts)
+				if err != nil {
+					t.Fatalf("processFileRandom: %v", err)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFileRandom: unexpected result (-want, +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_parser_normalizeOnce(t *testing.T) {
+	t.Parallel()
+
+	// More unique names than fit in the results for a chunk.
+	const n = 70000
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "S%d;1.0\n", i)
+	}
+	chunk := b.Bytes()
+
+	for name, opts := range map[string]*Options{
+		"fast path":    {Normalize: normalizeNFKC, FoldCase: true},
+		"default path": {Normalize: normalizeNFKC, FoldCase: true, SkipBlank: true},
+	} {
+		p := newParser(opts)
+		normalize := p.normalize
+		var calls int
+		p.normalize = func(name []byte) []byte {
+			calls++
+			return normalize(name)
+		}
+
+		for i := 0; i < 3; i++ {
+			m, err := p.processChunk(chunk, 0)
+			if err != nil {
+				t.Fatalf("%s: processChunk: %v", name, err)
+			}
+			if diff := cmp.Diff(n, len(m)); diff != "" {
+				t.Fatalf("%s: unexpected number of stations (-want, +got):\n%s", name, diff)
+			}
+			if _, ok := m["s0"]; !ok {
+				t.Fatalf("%s: missing folded name %q", name, "s0")
+			}
+		}
+		if diff := cmp.Diff(n, calls); diff != "" {
+			t.Errorf("%s: unexpected number of normalizations (-want, +got):\n%s", name, diff)
+		}
+	}
+}
+
+func Test_nameCompare(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		opts *Options
+		a, b string
+		want int
+	}{
+		"bytes": {
+			opts: &Options{},
+			a:    "Ålesund",
+			b:    "Zagreb",
+			want: 1,
+		},
+		"unicode": {
+			opts: &Options{Collate: "und"},
+			a:    "Ålesund",
+			b:    "Zagreb",
+			want: -1,
+		},
+		"case": {
+			opts: &Options{Collate: "und"},
+			a:    "adelaide",
+			b:    "Bosaso",
+			want: -1,
+		},
+		"equal": {
+			opts: &Options{Collate: "und"},
+			a:    "Zagreb",
+			b:    "Zagreb",
+			want: 0,
+		},
+		"windowed": {
+			opts: &Options{Collate: "und", Window: time.Hour},
+			a:    "2026-10-17T13:00:00ZAdelaide",
+			b:    "2026-10-17T12:00:00ZZagreb",
+			want: 1,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := nameCompare(tc.opts)(tc.a, tc.b); got != tc.want {
+				t.Fatalf("nameCompare(%q, %q): want %d, got %d", tc.a, tc.b, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDelim   = ';'
+	defaultDecimal = '.'
+	defaultScale   = 1
+
+	// maxScale is the maximum number of fractional digits supported.
+	maxScale = 9
+
+	// maxValueScale is the maximum number of fractional digits of
+	// aggregated values. See Options.valueScale.
+	maxValueScale = maxScale + 2
+)
+
+// Options configures how input files are read and parsed. The zero value is
+// the default configuration for the 1 billion row challenge input format.
+type Options struct {
+	// MaxLineLength is the maximum length in bytes of a line, not counting
+	// the newline. Longer lines result in a ParseError, including lines
+	// that span chunk or segment boundaries and the last line of the input.
+	// Zero means lines of any length are allowed.
+	MaxLineLength int
+
+	// CRLF accepts lines terminated by "\r\n".
+	CRLF bool
+
+	// SkipBOM skips a UTF-8 byte order mark at the start of the input.
+	SkipBOM bool
+
+	// CommentPrefix, if not empty, causes lines beginning with the prefix to
+	// be skipped.
+	CommentPrefix string
+
+	// SkipBlank skips empty lines.
+	SkipBlank bool
+
+	// Delim is the byte separating the station name from the value. Zero
+	// means ';'.
+	Delim byte
+
+	// Decimal is the decimal separator used in values. Zero means '.'.
+	Decimal byte
+
+	// Header skips the first line of the input.
+	Header bool
+
+	// Scale is the number of fractional digits kept for values. Values are
+	// aggregated as integers in units of 10^-Scale. Values with more
+	// fractional digits are rounded half away from zero. Zero means 1.
+	Scale int
+
+	// Strict enforces the 1 billion row challenge input rules. Station names
+	// must be 1 to 100 bytes of valid UTF-8, there may be at most 10,000
+	// unique stations, and values must be between -99.9 and 99.9 with
+	// exactly one fractional digit.
+	Strict bool
+
+	// MemoryBudget is the approximate number of bytes of memory used to hold
+	// results before they are spilled to sorted runs on disk. It also
+	// bounds the caches of filtered and rewritten station names. Zero means
+	// results are always held in memory.
+	MemoryBudget int64
+
+	// Columns names the value columns of rows with more than one value
+	// column. Rows have the station name followed by a value for each
+	// column. Values of columns after the first may be empty. Empty means
+	// rows have a single value column.
+	Columns []string
+
+	// Timestamp indicates that lines have a third field after the value
+	// with an RFC 3339 timestamp.
+	Timestamp bool
+
+	// Window, if not zero, groups results by tumbling windows of the given
+	// duration and station. Windows are aligned to the Unix epoch and
+	// identified by their start time in UTC. Window implies Timestamp.
+	Window time.Duration
+
+	// Stations, if not nil, selects the stations to include in the results
+	// by name. Stations, Prefix and Match select stations by their
+	// normalized name before any aliases or groups are applied.
+	Stations []string
+
+	// Prefix selects stations with names beginning with the prefix.
+	Prefix string
+
+	// Match, if not empty, is a regular expression that selects stations
+	// with matching names.
+	Match string
+
+	// Aliases, if not nil, maps station names to canonical names. Results
+	// for a station are aggregated under its canonical name.
+	Aliases map[string]string
+
+	// Groups, if not nil, maps canonical station names to group names, such
+	// as a country code. Results for stations in a group are aggregated
+	// under the group name. Stations not in a group keep their name.
+	Groups map[string]string
+
+	// GroupMatch, if not empty, is a regular expression that groups
+	// canonical station names by the text matched by its first capturing
+	// group, or by the whole match if it has no groups. Stations that don't
+	// match keep their name. GroupMatch cannot be used with Groups.
+	GroupMatch string
+
+	// Metadata, if not nil, is reference data for stations keyed by their
+	// canonical name. Metadata values are attached to results in JSON and
+	// CSV output unless stations are grouped.
+	Metadata *Metadata
+
+	// GroupBy, if not empty, groups stations by the value of the named
+	// metadata column. Stations missing from the metadata keep their name.
+	// GroupBy cannot be used with Groups or GroupMatch.
+	GroupBy string
+
+	// Where, if not nil, selects stations whose metadata has the given
+	// value for each column. Stations missing from the metadata are not
+	// selected.
+	Where map[string]string
+
+	// Normalize is the Unicode normalization form applied to station names:
+	// "none", "nfc" or "nfkc". Empty means "none".
+	Normalize string
+
+	// FoldCase applies Unicode case folding to station names.
+	FoldCase bool
+
+	// Format is the output format for results: "1brc", "json" or "csv".
+	// Empty means "1brc".
+	Format string
+
+	// Sort is the order of results: "name", "min", "max", "mean", "count"
+	// or "range". Stations with equal values are ordered by name. Empty
+	// means "name".
+	Sort string
+
+	// Desc reverses the order of results.
+	Desc bool
+
+	// Collate is the collation used to order station names: "bytes" or a
+	// BCP 47 language tag, such as "und" for the root Unicode collation.
+	// Empty means "bytes".
+	Collate string
+
+	// Top, if not zero, limits the results to the first Top stations in
+	// the result order.
+	Top int
+
+	// Unit is the temperature unit of results: "C", "F" or "K". Values are
+	// aggregated in degrees Celsius and converted when results are
+	// written. Empty means "C".
+	Unit string
+
+	// UnitSuffix allows values to end with a unit suffix of 'C', 'F' or
+	// 'K'. Values with a suffix are converted to degrees Celsius as they
+	// are parsed. Values without a suffix are in degrees Celsius. Values
+	// are kept with two more fractional digits than Scale so that they are
+	// only rounded to Scale when results are written.
+	UnitSuffix bool
+}
+
+// delim returns the field delimiter.
+func (o *Options) delim() byte {
+	if o.Delim == 0 {
+		return defaultDelim
+	}
+	return o.Delim
+}
+
+// decimal returns the decimal separator.
+func (o *Options) decimal() byte {
+	if o.Decimal == 0 {
+		return defaultDecimal
+	}
+	return o.Decimal
+}
+
+// scale returns the number of fractional digits kept for values.
+func (o *Options) scale() int {
+	if o.Scale == 0 {
+		return defaultScale
+	}
+	return o.Scale
+}
+
+// valueScale returns the number of fractional digits of parsed and
+// aggregated values. It is two more than scale if values may have unit
+// suffixes.
+func (o *Options) valueScale() int {
+	if o.UnitSuffix {
+		return o.scale() + 2
+	}
+	return o.scale()
+}
+
+// columns returns the number of value columns.
+func (o *Options) columns() int {
+	if len(o.Columns) == 0 {
+		return 1
+	}
+	return len(o.Columns)
+}
+
+// timestamps returns true if lines have a timestamp field.
+func (o *Options) timestamps() bool {
+	return o.Timestamp || o.Window > 0
+}
+
+// format returns the output format for results.
+func (o *Options) format() string {
+	if o.Format == "" {
+		return format1BRC
+	}
+	return o.Format
+}
+
+// unit returns the temperature unit of results.
+func (o *Options) unit() string {
+	if o.Unit == "" {
+		return unitCelsius
+	}
+	return o.Unit
+}
+
+// grouped returns true if stations are grouped.
+func (o *Options) grouped() bool {
+	return o.Groups != nil || o.GroupMatch != "" || o.GroupBy != ""
+}
+
+// sort returns the order of results.
+func (o *Options) sort() string {
+	if o.Sort == "" {
+		return sortName
+	}
+	return o.Sort
+}
+
+// nameOrder returns true if results are ordered by ascending name in byte
+// order.
+func (o *Options) nameOrder() bool {
+	_, collated, _ := parseCollation(o.Collate)
+	return o.sort() == sortName && !o.Desc && !collated
+}
+
+// validate returns an error if the options are inconsistent.
+func (o *Options) validate() error {
+	d, dec := o.delim(), o.decimal()
+	switch {
+	case d == '\n' || dec == '\n':
+		return errors.New("separators cannot be a newline")
+	case d == dec:
+		return fmt.Errorf("delimiter and decimal separator are both %q", d)
+	case dec == '-' || (dec >= '0' && dec <= '9'):
+		return fmt.Errorf("invalid decimal separator %q", dec)
+	case o.Scale < 0 || o.Scale > maxScale:
+		return fmt.Errorf("scale must be between 1 and %d", maxScale)
+	case o.MemoryBudget < 0:
+		return errors.New("memory budget cannot be negative")
+	case o.Strict && o.MemoryBudget > 0:
+		return errors.New("strict mode cannot be used with a memory budget")
+	case o.Window < 0 || o.Window%time.Second != 0:
+		return errors.New("window must be a positive whole number of seconds")
+	case o.Strict && o.timestamps():
+		return errors.New("strict mode cannot be used with timestamps")
+	case o.Strict && o.columns() > 1:
+		return errors.New("strict mode cannot be used with multiple value columns")
+	case !validFormat(o.format()):
+		return fmt.Errorf("unknown output format %q", o.Format)
+	case !validSort(o.sort()):
+		return fmt.Errorf("unknown sort order %q", o.Sort)
+	case !validUnit(o.unit()):
+		return fmt.Errorf("unknown unit %q", o.Unit)
+	case o.Strict && o.UnitSuffix:
+		return errors.New("strict mode cannot be used with unit suffixes")
+	case !validNormalize(o.Normalize):
+		return fmt.Errorf("unknown normalization form %q", o.Normalize)
+	case o.Top < 0:
+		return errors.New("top cannot be negative")
+	case o.MemoryBudget > 0 && !o.nameOrder() && o.Top == 0:
+		return errors.New("sorting results with a memory budget requires a top limit")
+	}
+	for _, c := range o.Columns {
+		if c == "" {
+			return errors.New("column names cannot be empty")
+		}
+	}
+	if _, err := newStationFilter(o); err != nil {
+		return err
+	}
+	if _, err := newKeyRewriter(o); err != nil {
+		return err
+	}
+	if _, _, err := parseCollation(o.Collate); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseSeparator parses a single byte separator given on the command line.
+// The escape sequence "\t" is accepted for a tab.
+func parseSeparator(s string) (byte, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	if len(s) != 1 {
+		return 0, fmt.Errorf("separator %q must be a single byte", s)
+	}
+	return s[0], nil
+}
+
+// parseSize parses a size in bytes with an optional K, M, or G suffix for
+// powers of 1024. An empty string is zero.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	mult := int64(1)
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		mult = 1 << 10
+	case 'm', 'M':
+		mult = 1 << 20
+	case 'g', 'G':
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n < 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return n * mult, nil
+}
+
+// parseWindow parses a window duration given on the command line. In addition
+// to the units accepted by time.ParseDuration a "d" suffix is accepted for
+// days. An empty string is zero.
+func parseWindow(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
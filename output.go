@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	// format1BRC is the output format expected for the 1 billion row
+	// challenge.
+	format1BRC = "1brc"
+
+	// formatCSV is a CSV output format with a header row.
+	formatCSV = "csv"
+)
+
+// statNames are the names of the stats written for each value column.
+var statNames = []string{"min", "mean", "max", "count"}
+
+// validFormat returns true if format is a known output format.
+func validFormat(format string) bool {
+	switch format {
+	case format1BRC, formatJSON, formatCSV:
+		return true
+	}
+	return false
+}
+
+// stationResult is the result for a single station. Values are formatted with
+// a fixed number of fractional digits.
+type stationResult struct {
+	// Window is the start time of the window for windowed results.
+	Window string `json:"window,omitempty"`
+
+	// Name, Min, Mean, Max and Count are the results for the first value
+	// column.
+	Name  string      `json:"name"`
+	Min   json.Number `json:"min"`
+	Mean  json.Number `json:"mean"`
+	Max   json.Number `json:"max"`
+	Count int         `json:"count"`
+
+	// Columns holds the results for each value column if there is more
+	// than one.
+	Columns []columnResult `json:"columns,omitempty"`
+
+	// Metadata holds the station's metadata values keyed by column.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// columnResult is the result for a value column of a station. Min, Mean and
+// Max are empty if the column has no values.
+type columnResult struct {
+	Name  string      `json:"name"`
+	Min   json.Number `json:"min,omitempty"`
+	Mean  json.Number `json:"mean,omitempty"`
+	Max   json.Number `json:"max,omitempty"`
+	Count int         `json:"count"`
+}
+
+// newColumnResult returns the result for a value column with values in units
+// of 10^-valueScale degrees Celsius. Results are converted to unit and written
+// with scale fractional digits.
+func newColumnResult(name string, info *TempInfo, valueScale, scale int, unit string) columnResult {
+	r := columnResult{
+		Name:  name,
+		Count: info.Count,
+	}
+	if info.Count == 0 {
+		return r
+	}
+	div := math.Pow10(scale)
+	formatValue := func(v float64) json.Number {
+		return json.Number(strconv.FormatFloat(v, 'f', scale, 64))
+	}
+	if unit != "" && unit != unitCelsius || valueScale != scale {
+		r.Min = formatValue(float64(fromCelsius(unit, info.Min, 1, valueScale, scale)) / div)
+		r.Mean = formatValue(float64(fromCelsius(unit, info.Sum, info.Count, valueScale, scale)) / div)
+		r.Max = formatValue(float64(fromCelsius(unit, info.Max, 1, valueScale, scale)) / div)
+		return r
+	}
+	r.Min = formatValue(float64(info.Min) / div)
+	r.Mean = formatValue(roundScale(float64(info.Sum)/div/float64(info.Count), scale))
+	r.Max = formatValue(float64(info.Max) / div)
+	return r
+}
+
+// newStationResult returns the result for a single station with values in
+// units of 10^-valueScale degrees Celsius converted to unit and written with
+// scale fractional digits. columns names the value columns if there is more
+// than one.
+func newStationResult(name string, info *TempInfo, valueScale, scale int, unit string, columns []string) stationResult {
+	first := newColumnResult(name, info, valueScale, scale, unit)
+	r := stationResult{
+		Name:  name,
+		Min:   first.Min,
+		Mean:  first.Mean,
+		Max:   first.Max,
+		Count: first.Count,
+	}
+	if len(columns) > 1 {
+		r.Columns = make([]columnResult, len(columns))
+		for i, c := range columns {
+			col := info
+			if i > 0 {
+				col = &TempInfo{}
+				if i-1 < len(info.Extra) {
+					col = &info.Extra[i-1]
+				}
+			}
+			r.Columns[i] = newColumnResult(c, col, valueScale, scale, unit)
+		}
+	}
+	return r
+}
+
+// resultWriter writes results in one of the output formats. Results must be
+// written in order.
+type resultWriter struct {
+	w      *bufio.Writer
+	csv    *csv.Writer
+	format string
+	unit   string
+	n      int
+
+	// valueScale is the number of fractional digits of values and scale
+	// is the number written.
+	valueScale, scale int
+
+	// windowed is true if station names are windowed keys.
+	windowed bool
+
+	// columns names the value columns if there is more than one.
+	columns []string
+
+	// opts and metadata, if not nil, are used to attach station metadata
+	// to results.
+	opts     *Options
+	metadata *Metadata
+}
+
+// newResultWriter returns a new resultWriter that writes to w in the format
+// given by opts. Values are written with opts.Scale fractional digits.
+func newResultWriter(w io.Writer, opts *Options) *resultWriter {
+	rw := &resultWriter{
+		w:          bufio.NewWriter(w),
+		format:     opts.format(),
+		unit:       opts.unit(),
+		valueScale: opts.valueScale(),
+		scale:      opts.scale(),
+		windowed:   opts.Window > 0,
+	}
+	if len(opts.Columns) > 1 {
+		rw.columns = opts.Columns
+	}
+	if opts.Metadata != nil && !opts.grouped() {
+		rw.opts, rw.metadata = opts, opts.Metadata
+	}
+	if rw.format == formatCSV {
+		rw.csv = csv.NewWriter(rw.w)
+	}
+	return rw
+}
+
+// header returns the CSV header row.
+func (rw *resultWriter) header() []string {
+	var h []string
+	if rw.windowed {
+		h = append(h, "window")
+	}
+	h = append(h, "station")
+	if rw.columns == nil {
+		h = append(h, statNames...)
+	}
+	for _, c := range rw.columns {
+		for _, s := range statNames {
+			h = append(h, c+"_"+s)
+		}
+	}
+	if rw.metadata != nil {
+		h = append(h, rw.metadata.Columns...)
+	}
+	return h
+}
+
+// write writes the result for a single station. If the results are windowed
+// name is a windowed key.
+func (rw *resultWriter) write(name string, info *TempInfo) {
+	var window string
+	if rw.windowed {
+		window, name = splitWindowKey(name)
+	}
+	r := newStationResult(name, info, rw.valueScale, rw.scale, rw.unit, rw.columns)
+	r.Window = window
+	if rw.metadata != nil {
+		r.Metadata = stationMetadata(name, rw.opts)
+	}
+
+	cols := r.Columns
+	if cols == nil {
+		cols = []columnResult{{Min: r.Min, Mean: r.Mean, Max: r.Max, Count: r.Count}}
+	}
+
+	switch rw.format {
+	case formatJSON:
+		if rw.n == 0 {
+			rw.w.WriteByte('[')
+		} else {
+			rw.w.WriteByte(',')
+		}
+		b, _ := json.Marshal(r)
+		rw.w.Write(b)
+	case formatCSV:
+		if rw.n == 0 {
+			rw.csv.Write(rw.header())
+		}
+		var row []string
+		if rw.windowed {
+			row = append(row, r.Window)
+		}
+		row = append(row, r.Name)
+		for _, c := range cols {
+			row = append(row, string(c.Min), string(c.Mean), string(c.Max), strconv.Itoa(c.Count))
+		}
+		if rw.metadata != nil {
+			for _, c := range rw.metadata.Columns {
+				row = append(row, r.Metadata[c])
+			}
+		}
+		rw.csv.Write(row)
+	default:
+		if rw.n == 0 {
+			rw.w.WriteByte('{')
+		} else {
+			rw.w.WriteString(", ")
+		}
+		if rw.windowed {
+			rw.w.WriteString(window)
+			rw.w.WriteByte(' ')
+		}
+		rw.w.WriteString(name)
+		rw.w.WriteByte('=')
+		for i, c := range cols {
+			if i > 0 {
+				rw.w.WriteByte(';')
+			}
+			if c.Count > 0 {
+				rw.w.WriteString(string(c.Min))
+				rw.w.WriteByte('/')
+				rw.w.WriteString(string(c.Mean))
+				rw.w.WriteByte('/')
+				rw.w.WriteString(string(c.Max))
+			}
+		}
+	}
+	rw.n++
+}
+
+// close finishes writing the results and flushes the underlying writer.
+func (rw *resultWriter) close() error {
+	switch rw.format {
+	case formatJSON:
+		if rw.n == 0 {
+			rw.w.WriteByte('[')
+		}
+		rw.w.WriteString("]\n")
+	case formatCSV:
+		if rw.n == 0 {
+			rw.csv.Write(rw.header())
+		}
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	default:
+		if rw.n == 0 {
+			rw.w.WriteByte('{')
+		}
+		rw.w.WriteString("}\n")
+	}
+	return rw.w.Flush()
+}
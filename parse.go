@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+// utf8BOM is the UTF-8 byte order mark.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// parser processes chunks of input according to Options. Chunks in the default
+// input format are handled by the fast path in processChunk.
+type parser struct {
+	opts *Options
+
+	// fast is true if the input can be processed by processChunk.
+	fast bool
+
+	delim   byte
+	decimal byte
+	scale   int
+
+	// maxValue is the maximum absolute value allowed in strict mode.
+	maxValue int
+
+	// timestamps is true if lines have a timestamp field.
+	timestamps bool
+
+	// split is true if the value field is followed by other fields.
+	split bool
+
+	// extra and extraOK hold the values of the value columns after the
+	// first for the current line. extraOK is false for empty values.
+	extra   []int
+	extraOK []bool
+
+	// window is the window size in seconds or zero if results are not
+	// windowed.
+	window int64
+
+	// key holds the windowed key for the current line. The start of the
+	// window for the key is lastWindow.
+	key        []byte
+	lastWindow int64
+
+	// filter, if not nil, selects the stations to include in the results.
+	filter *stationFilter
+
+	// normalize, if not nil, normalizes station names.
+	normalize func(name []byte) []byte
+
+	// rewriter, if not nil, rewrites station names to aggregation keys.
+	rewriter *keyRewriter
+
+	// keyCache caches the filter and rewrite results for each station name
+	// if names are normalized, filtered or rewritten, or checked against
+	// metadata. Like the results, it grows with the number of unique
+	// station names unless keyCacheBudget is set, in which case it is
+	// cleared before its estimated size in bytes, keyCacheSize, exceeds
+	// keyCacheBudget.
+	keyCache       map[string]stationKey
+	keyCacheSize   int64
+	keyCacheBudget int64
+
+	// err is an error in the options that is returned when processing
+	// chunks.
+	err error
+
+	// collect causes invalid lines to be skipped and recorded in violations
+	// rather than returned as an error.
+	collect bool
+
+	// violations holds up to maxViolations errors for invalid lines when
+	// collect is true.
+	violations    []*ParseError
+	maxViolations int
+
+	// numViolations is the total number of invalid lines seen.
+	numViolations int
+
+	// lastViolation is the error for the most recent invalid line when
+	// collect is true.
+	lastViolation *ParseError
+}
+
+// newParser returns a new parser for the given options.
+func newParser(opts *Options) *parser {
+	delim, decimal, scale := opts.delim(), opts.decimal(), opts.valueScale()
+	p := &parser{
+		opts: opts,
+		fast: !opts.CRLF && !opts.SkipBlank && opts.CommentPrefix == "" && !opts.Strict &&
+			!opts.timestamps() && opts.columns() == 1 && !opts.UnitSuffix &&
+			delim == defaultDelim && decimal == defaultDecimal && scale == defaultScale,
+		delim:      delim,
+		decimal:    decimal,
+		scale:      scale,
+		maxValue:   maxStrictValue * int(math.Pow10(scale-1)),
+		timestamps: opts.timestamps(),
+		split:      opts.timestamps() || opts.columns() > 1,
+		extra:      make([]int, opts.columns()-1),
+		extraOK:    make([]bool, opts.columns()-1),
+		window:     int64(opts.Window / time.Second),
+	}
+	p.filter, p.err = newStationFilter(opts)
+	if p.err == nil {
+		p.rewriter, p.err = newKeyRewriter(opts)
+	}
+	p.normalize = newNormalizer(opts)
+	if p.filter != nil || p.rewriter != nil || p.normalize != nil || opts.Metadata != nil {
+		p.keyCache = make(map[string]stationKey)
+		if opts.MemoryBudget > 0 {
+			p.keyCacheBudget = keyCacheBudget(opts.MemoryBudget)
+		}
+	}
+	return p
+}
+
+// trimPreamble removes data that may only occur at the start of the input
+// from the first chunk b.
+func (p *parser) trimPreamble(b []byte) []byte {
+	if p.opts.SkipBOM {
+		b = bytes.TrimPrefix(b, utf8BOM)
+	}
+	if p.opts.Header {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[i+1:]
+		} else {
+			b = b[len(b):]
+		}
+	}
+	return b
+}
+
+// processChunk reads an input chunk that starts at the given byte offset in
+// the input. Chunks should be comprised of full lines.
+func (p *parser) processChunk(b []byte, offset int64) (map[string]*TempInfo, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.fast && p.longLine(b) {
+		// Parse the chunk line by line to report or skip the long lines.
+		return p.processLines(b, offset)
+	}
+	if p.fast {
+		m, err := p.processChunkFast(b)
+		if err != nil && p.collect {
+			// Parse the chunk again to skip invalid lines.
+			return p.processLines(b, offset)
+		}
+		if err != nil {
+			// Parse the chunk again to find the offset of the error.
+			if _, perr := p.processLines(b, offset); perr != nil {
+				err = perr
+			}
+		}
+		return m, err
+	}
+	return p.processLines(b, offset)
+}
+
+// longLine returns true if b has a line longer than opts.MaxLineLength.
+func (p *parser) longLine(b []byte) bool {
+	maxLen := p.opts.MaxLineLength
+	if maxLen <= 0 {
+		return false
+	}
+	for len(b) > maxLen {
+		i := bytes.IndexByte(b[:maxLen+1], '\n')
+		if i < 0 {
+			return true
+		}
+		b = b[i+1:]
+	}
+	return false
+}
+
+// processChunkFast reads an input chunk with the fast path. Each station name
+// in the chunk is filtered and rewritten when it is first seen.
+func (p *parser) processChunkFast(b []byte) (map[string]*TempInfo, error) {
+	if p.keyCache == nil {
+		return processChunk(b)
+	}
+	out := make(map[string]*TempInfo, maxCities)
+	_, err := processChunkKeys(b, func(name string, num int) *TempInfo {
+		k := p.lookupKey([]byte(name))
+		if !k.keep {
+			return nil
+		}
+		if k.name != nil {
+			name = string(k.name)
+		}
+		info, ok := out[name]
+		if !ok {
+			info = &TempInfo{}
+			out[name] = info
+		}
+		info.add(num)
+		return info
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// processLines reads an input chunk line by line according to the parser's
+// options.
+func (p *parser) processLines(b []byte, offset int64) (map[string]*TempInfo, error) {
+	m := make(map[string]*TempInfo, maxCities)
+	for pos := 0; pos < len(b); {
+		lineOffset := offset + int64(pos)
+		line := b[pos:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+			pos += i + 1
+		} else {
+			pos = len(b)
+		}
+
+		name, num, skip, err := p.parseLine(line)
+		if err == nil && !skip {
+			info, ok := m[string(name)]
+			switch {
+			case ok:
+				if num < info.Min {
+					info.Min = num
+				}
+				info.Sum += num
+				if num > info.Max {
+					info.Max = num
+				}
+				info.Count++
+			case p.opts.Strict:
+				err = checkStation(name)
+			}
+			if !ok && err == nil {
+				info = &TempInfo{
+					Min:   num,
+					Sum:   num,
+					Max:   num,
+					Count: 1,
+				}
+				if len(p.extra) > 0 {
+					info.Extra = make([]TempInfo, len(p.extra))
+				}
+				m[string(name)] = info
+			}
+			if err == nil {
+				for i, ok := range p.extraOK {
+					if ok {
+						info.Extra[i].add(p.extra[i])
+					}
+				}
+			}
+		}
+		if err != nil {
+			perr := &ParseError{
+				Offset: lineOffset,
+				Err:    err,
+			}
+			if !p.collect {
+				return nil, perr
+			}
+			p.numViolations++
+			p.lastViolation = perr
+			if len(p.violations) < p.maxViolations {
+				p.violations = append(p.violations, perr)
+			}
+		}
+	}
+	return m, nil
+}
+
+// eachLine reads an input chunk line by line according to the parser's options
+// and calls fn with the key, value and byte offset of each line. The chunk
+// starts at the given byte offset in the input.
+func (p *parser) eachLine(b []byte, offset int64, fn func(name []byte, num int, offset int64)) error {
+	if p.err != nil {
+		return p.err
+	}
+	for pos := 0; pos < len(b); {
+		lineOffset := offset + int64(pos)
+		line := b[pos:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+			pos += i + 1
+		} else {
+			pos = len(b)
+		}
+
+		name, num, skip, err := p.parseLine(line)
+		if err != nil {
+			return &ParseError{
+				Offset: lineOffset,
+				Err:    err,
+			}
+		}
+		if !skip {
+			fn(name, num, lineOffset)
+		}
+	}
+	return nil
+}
+
+// parseLine parses a single line without the trailing newline. skip is true
+// if the line should be ignored.
+func (p *parser) parseLine(line []byte) (name []byte, num int, skip bool, err error) {
+	if p.opts.MaxLineLength > 0 && len(line) > p.opts.MaxLineLength {
+		return nil, 0, false, fmt.Errorf("%w: line exceeds maximum length of %d bytes", errInputFormat, p.opts.MaxLineLength)
+	}
+	if p.opts.CRLF && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if len(line) == 0 {
+		if p.opts.SkipBlank {
+			return nil, 0, true, nil
+		}
+		return nil, 0, false, fmt.Errorf("%w: empty line", errInputFormat)
+	}
+	if p.opts.CommentPrefix != "" && bytes.HasPrefix(line, []byte(p.opts.CommentPrefix)) {
+		return nil, 0, true, nil
+	}
+
+	sep := bytes.IndexByte(line, p.delim)
+	if sep < 0 {
+		return nil, 0, false, fmt.Errorf("%w: missing delimiter %q", errInputFormat, p.delim)
+	}
+	name, value := line[:sep], line[sep+1:]
+	var ts []byte
+	if p.split {
+		var rest []byte
+		value, rest = cutField(value, p.delim)
+		for i := range p.extra {
+			if rest == nil {
+				return nil, 0, false, fmt.Errorf("%w: missing column %q", errInputFormat, p.opts.Columns[i+1])
+			}
+			var field []byte
+			field, rest = cutField(rest, p.delim)
+			p.extraOK[i] = len(field) > 0
+			if p.extraOK[i] {
+				if p.extra[i], err = p.parseValue(field); err != nil {
+					return nil, 0, false, err
+				}
+			}
+		}
+		switch {
+		case p.timestamps && rest == nil:
+			return nil, 0, false, fmt.Errorf("%w: missing timestamp", errInputFormat)
+		case p.timestamps:
+			ts = rest
+		case rest != nil:
+			return nil, 0, false, fmt.Errorf("%w: too many fields", errInputFormat)
+		}
+	}
+	if len(value) == 0 {
+		return nil, 0, false, fmt.Errorf("%w: missing value", errInputFormat)
+	}
+	num, err = p.parseValue(value)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if p.opts.Strict {
+		if len(value) < 3 || value[len(value)-2] != p.decimal {
+			return nil, 0, false, fmt.Errorf("%w: value %q must have exactly one fractional digit", errInputFormat, value)
+		}
+		if num < -p.maxValue || num > p.maxValue {
+			return nil, 0, false, fmt.Errorf("%w: value %q out of range", errInputFormat, value)
+		}
+	}
+	if p.keyCache != nil {
+		k := p.lookupKey(name)
+		skip = !k.keep
+		if k.name != nil {
+			name = k.name
+		}
+	}
+	if p.timestamps {
+		sec, err := parseTimestamp(ts)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if p.window > 0 {
+			if name, err = p.windowKey(name, sec); err != nil {
+				return nil, 0, false, err
+			}
+		}
+	}
+	return name, num, skip, nil
+}
+
+// parseValue parses a value field. If unit suffixes are allowed, values with
+// a unit suffix are converted to degrees Celsius.
+func (p *parser) parseValue(field []byte) (int, error) {
+	if !p.opts.UnitSuffix {
+		return parseDecimal(field, p.decimal, p.scale)
+	}
+	field, unit := unitSuffix(field)
+	v, err := parseDecimal(field, p.decimal, p.scale)
+	if err != nil || unit == unitCelsius {
+		return v, err
+	}
+	return toCelsius(unit, v, p.scale)
+}
+
+// cutField returns the field at the start of b up to the delimiter and the rest
+// of b after the delimiter. rest is nil if b has no delimiter.
+func cutField(b []byte, delim byte) (field, rest []byte) {
+	if i := bytes.IndexByte(b, delim); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+// windowKey returns the windowed key for the station name at the Unix time
+// sec. The key is only valid until the next call to windowKey.
+func (p *parser) windowKey(name []byte, sec int64) ([]byte, error) {
+	start := windowStart(sec, p.window)
+	if len(p.key) < windowKeyLen || start != p.lastWindow {
+		key, err := appendWindowKey(p.key[:0], start, name)
+		if err != nil {
+			return nil, err
+		}
+		p.key, p.lastWindow = key, start
+		return p.key, nil
+	}
+	p.key = append(p.key[:windowKeyLen], name...)
+	return p.key, nil
+}
+
+// maxDigits is the maximum number of significant digits in a value.
+const maxDigits = 18
+
+// parseDecimal converts a decimal number s using the given decimal separator
+// to an integer in units of 10^-scale. Numbers with fewer fractional digits
+// than scale are padded and numbers with more are rounded half away from
+// zero. The number must have an optional leading '-', at least one integer
+// digit, and, if there is a decimal separator, at least one fractional digit.
+func parseDecimal[T ~string | ~[]byte](s T, decimal byte, scale int) (int, error) {
+	i := 0
+	isNegative := len(s) > 0 && s[0] == '-'
+	if isNegative {
+		i++
+	}
+
+	var n, digits int
+	start := i
+	for ; i < len(s) && s[i] != decimal; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, fmt.Errorf("%w: invalid number %q", errInputFormat, s)
+		}
+		n = n*10 + int(s[i]-'0')
+		digits++
+	}
+	if i == start {
+		return 0, fmt.Errorf("%w: invalid number %q", errInputFormat, s)
+	}
+
+	frac := 0
+	if i < len(s) {
+		// Skip the decimal separator.
+		i++
+		if i == len(s) {
+			return 0, fmt.Errorf("%w: invalid number %q", errInputFormat, s)
+		}
+		roundUp := false
+		for ; i < len(s); i++ {
+			if s[i] < '0' || s[i] > '9' {
+				return 0, fmt.Errorf("%w: invalid number %q", errInputFormat, s)
+			}
+			switch {
+			case frac < scale:
+				n = n*10 + int(s[i]-'0')
+				digits++
+			case frac == scale:
+				roundUp = s[i] >= '5'
+			}
+			frac++
+		}
+		if roundUp {
+			n++
+		}
+	}
+	for ; frac < scale; frac++ {
+		n *= 10
+		digits++
+	}
+	if digits > maxDigits {
+		return 0, fmt.Errorf("%w: number %q out of range", errInputFormat, s)
+	}
+
+	if isNegative {
+		n *= -1
+	}
+	return n, nil
+}
@@ -0,0 +1,939 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_parser_processChunk(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		chunk    []byte
+		opts     *Options
+		expected map[string]*TempInfo
+		err      error
+	}{
+		"default": {
+			chunk: []byte("Halifax;3.0\nHalifax;1.0\n"),
+			opts:  &Options{},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 2,
+				},
+			},
+		},
+		"crlf": {
+			chunk: []byte("Halifax;3.0\r\nHalifax;1.0\r\n"),
+			opts:  &Options{CRLF: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 2,
+				},
+			},
+		},
+		"crlf mixed line endings": {
+			chunk: []byte("Halifax;3.0\r\nHalifax;1.0\n"),
+			opts:  &Options{CRLF: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 2,
+				},
+			},
+		},
+		"skip blank": {
+			chunk: []byte("\nHalifax;3.0\n\n\nHalifax;1.0\n"),
+			opts:  &Options{SkipBlank: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 2,
+				},
+			},
+		},
+		"skip blank crlf": {
+			chunk: []byte("Halifax;3.0\r\n\r\nHalifax;1.0\r\n"),
+			opts:  &Options{CRLF: true, SkipBlank: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   10,
+					Max:   30,
+					Sum:   40,
+					Count: 2,
+				},
+			},
+		},
+		"blank line": {
+			chunk: []byte("Halifax;3.0\r\n\r\nHalifax;1.0\r\n"),
+			opts:  &Options{CRLF: true},
+			err:   errInputFormat,
+		},
+		"comment": {
+			chunk: []byte("# comment\nHalifax;3.0\n#Halifax;1.0\n"),
+			opts:  &Options{CommentPrefix: "#"},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   30,
+					Max:   30,
+					Sum:   30,
+					Count: 1,
+				},
+			},
+		},
+		"delimiter and decimal": {
+			chunk: []byte("Halifax\t3,0\nHalifax\t-1,5\n"),
+			opts:  &Options{Delim: '\t', Decimal: ','},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -15,
+					Max:   30,
+					Sum:   15,
+					Count: 2,
+				},
+			},
+		},
+		"decimal comma semicolon delimiter": {
+			chunk: []byte("Halifax;3,0\n"),
+			opts:  &Options{Decimal: ','},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   30,
+					Max:   30,
+					Sum:   30,
+					Count: 1,
+				},
+			},
+		},
+		"scale": {
+			chunk: []byte("Halifax;3.25\nHalifax;1\nHalifax;-0.5\n"),
+			opts:  &Options{Scale: 2},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -50,
+					Max:   325,
+					Sum:   375,
+					Count: 3,
+				},
+			},
+		},
+		"scale rounding": {
+			chunk: []byte("Halifax;3,255\nHalifax;-3,255\n"),
+			opts:  &Options{Decimal: ',', Scale: 2},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -326,
+					Max:   326,
+					Sum:   0,
+					Count: 2,
+				},
+			},
+		},
+		"malformed number": {
+			chunk: []byte("Halifax;1.2.3\n"),
+			opts:  &Options{Scale: 2},
+			err:   errInputFormat,
+		},
+		"strict": {
+			chunk: []byte("Halifax;-99.9\nHalifax;99.9\n"),
+			opts:  &Options{Strict: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -999,
+					Max:   999,
+					Sum:   0,
+					Count: 2,
+				},
+			},
+		},
+		"strict out of range": {
+			chunk: []byte("Halifax;100.0\n"),
+			opts:  &Options{Strict: true},
+			err:   errInputFormat,
+		},
+		"strict fractional digits": {
+			chunk: []byte("Halifax;10\n"),
+			opts:  &Options{Strict: true},
+			err:   errInputFormat,
+		},
+		"strict station name too long": {
+			chunk: []byte(strings.Repeat("a", 101) + ";1.0\n"),
+			opts:  &Options{Strict: true},
+			err:   errInputFormat,
+		},
+		"strict invalid utf8": {
+			chunk: []byte("\xff;1.0\n"),
+			opts:  &Options{Strict: true},
+			err:   errInputFormat,
+		},
+		"wrong delimiter": {
+			chunk: []byte("Halifax;3.0\n"),
+			opts:  &Options{Delim: ','},
+			err:   errInputFormat,
+		},
+		"no semicolon": {
+			chunk: []byte("Halifax\n"),
+			opts:  &Options{SkipBlank: true},
+			err:   errInputFormat,
+		},
+		"no number": {
+			chunk: []byte("Halifax;\r\n"),
+			opts:  &Options{CRLF: true},
+			err:   errInputFormat,
+		},
+		"unit suffix": {
+			chunk: []byte("Halifax;50.0F\nHalifax;283.15K\nHalifax;-1.0C\nHalifax;2.0\nHalifax;0.1F\n"),
+			opts:  &Options{UnitSuffix: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -17722,
+					Max:   10000,
+					Sum:   -17722 + 10000 + 10000 - 1000 + 2000,
+					Count: 5,
+				},
+			},
+		},
+		"unit suffix disabled": {
+			chunk: []byte("Halifax;50.0F\n"),
+			opts:  &Options{},
+			err:   errInputFormat,
+		},
+		"unit suffix only": {
+			chunk: []byte("Halifax;F\n"),
+			opts:  &Options{UnitSuffix: true},
+			err:   errInputFormat,
+		},
+		"unit suffix columns": {
+			chunk: []byte("Halifax;32.0F;273.15K\n"),
+			opts:  &Options{UnitSuffix: true, Columns: []string{"low", "high"}},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   0,
+					Max:   0,
+					Sum:   0,
+					Count: 1,
+					Extra: []TempInfo{{Min: 0, Max: 0, Sum: 0, Count: 1}},
+				},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m, err := newParser(tc.opts).processChunk(tc.chunk, 0)
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expected, m); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_processFile_options(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path     string
+		opts     *Options
+		expected map[string]*TempInfo
+		err      error
+	}{
+		"crlf bom": {
+			path: "test/options/measurements-crlf.txt",
+			opts: &Options{CRLF: true, SkipBOM: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"Zagreb": {
+					Min:   122,
+					Max:   122,
+					Sum:   122,
+					Count: 1,
+				},
+				"Cabo San Lucas": {
+					Min:   149,
+					Max:   149,
+					Sum:   149,
+					Count: 1,
+				},
+			},
+		},
+		"comments blank lines": {
+			path: "test/options/measurements-comments.txt",
+			opts: &Options{CommentPrefix: "#", SkipBlank: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"Zagreb": {
+					Min:   122,
+					Max:   122,
+					Sum:   122,
+					Count: 1,
+				},
+			},
+		},
+		"european header": {
+			path: "test/options/measurements-european.txt",
+			opts: &Options{Decimal: ',', Header: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"Zagreb": {
+					Min:   122,
+					Max:   122,
+					Sum:   122,
+					Count: 1,
+				},
+				"Cabo San Lucas": {
+					Min:   149,
+					Max:   149,
+					Sum:   149,
+					Count: 1,
+				},
+			},
+		},
+		"tab header": {
+			path: "test/options/measurements-tab.txt",
+			opts: &Options{Delim: '\t', Decimal: ',', Header: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"Zagreb": {
+					Min:   122,
+					Max:   122,
+					Sum:   122,
+					Count: 1,
+				},
+				"Cabo San Lucas": {
+					Min:   149,
+					Max:   149,
+					Sum:   149,
+					Count: 1,
+				},
+			},
+		},
+		"comments strict": {
+			path: "test/options/measurements-comments.txt",
+			opts: &Options{},
+			err:  errInputFormat,
+		},
+		"timestamps": {
+			path: "test/options/measurements-timestamps.txt",
+			opts: &Options{Timestamp: true},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   148,
+					Count: 3,
+				},
+				"Zagreb": {
+					Min:   -5,
+					Max:   122,
+					Sum:   131,
+					Count: 3,
+				},
+			},
+		},
+		"hourly windows": {
+			path: "test/options/measurements-timestamps.txt",
+			opts: &Options{Window: time.Hour},
+			expected: map[string]*TempInfo{
+				"2026-10-17T12:00:00ZHalifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+				},
+				"2026-10-17T12:00:00ZZagreb": {
+					Min:   14,
+					Max:   122,
+					Sum:   136,
+					Count: 2,
+				},
+				"2026-10-17T13:00:00ZHalifax": {
+					Min:   50,
+					Max:   50,
+					Sum:   50,
+					Count: 1,
+				},
+				"2026-10-18T00:00:00ZZagreb": {
+					Min:   -5,
+					Max:   -5,
+					Sum:   -5,
+					Count: 1,
+				},
+			},
+		},
+		"daily windows": {
+			path: "test/options/measurements-timestamps.txt",
+			opts: &Options{Window: 24 * time.Hour},
+			expected: map[string]*TempInfo{
+				"2026-10-17T00:00:00ZHalifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   148,
+					Count: 3,
+				},
+				"2026-10-17T00:00:00ZZagreb": {
+					Min:   14,
+					Max:   122,
+					Sum:   136,
+					Count: 2,
+				},
+				"2026-10-18T00:00:00ZZagreb": {
+					Min:   -5,
+					Max:   -5,
+					Sum:   -5,
+					Count: 1,
+				},
+			},
+		},
+		"missing timestamps": {
+			path: "test/options/measurements-european.txt",
+			opts: &Options{Decimal: ',', Header: true, Timestamp: true},
+			err:  errInputFormat,
+		},
+		"columns": {
+			path: "test/options/measurements-columns.txt",
+			opts: &Options{Header: true, Columns: []string{"temperature", "humidity", "pressure"}},
+			expected: map[string]*TempInfo{
+				"Halifax": {
+					Min:   -31,
+					Max:   129,
+					Sum:   98,
+					Count: 2,
+					Extra: []TempInfo{
+						{Min: 400, Max: 700, Sum: 1100, Count: 2},
+						{Min: 10040, Max: 10200, Sum: 20240, Count: 2},
+					},
+				},
+				"Zagreb": {
+					Min:   14,
+					Max:   122,
+					Sum:   136,
+					Count: 2,
+					Extra: []TempInfo{
+						{Min: 555, Max: 555, Sum: 555, Count: 1},
+						{Min: 10135, Max: 10135, Sum: 10135, Count: 1},
+					},
+				},
+			},
+		},
+		"missing column": {
+			path: "test/options/measurements-columns.txt",
+			opts: &Options{Header: true, Columns: []string{"temperature", "humidity", "pressure", "wind"}},
+			err:  errInputFormat,
+		},
+		"too many columns": {
+			path: "test/options/measurements-columns.txt",
+			opts: &Options{Header: true, Columns: []string{"temperature", "humidity"}},
+			err:  errInputFormat,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, size := range []int{5, 16, chunkSize} {
+				f, err := os.Open(tc.path)
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				defer f.Close()
+
+				m, err := processFile(f, size, tc.opts)
+				if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+					t.Fatalf("processFile(%d): unexpected error (-want, +got):\n%s", size, diff)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFile(%d): unexpected result (-want, +got):\n%s", size, diff)
+				}
+
+				m, err = processFileRandom(tc.path, size, tc.opts)
+				if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+					t.Fatalf("processFileRandom(%d): unexpected error (-want, +got):\n%s", size, diff)
+				}
+				if diff := cmp.Diff(tc.expected, m); diff != "" {
+					t.Fatalf("processFileRandom(%d): unexpected result (-want, +got):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_processFile_errorOffset(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		input  string
+		opts   *Options
+		offset int64
+	}{
+		"default": {
+			input:  "foo;1.0\nbar;2.0\nbaz;+3.0\nqux;4.0\n",
+			opts:   &Options{},
+			offset: 16,
+		},
+		"missing value": {
+			input:  "foo;1.0\nbar;2.0\nbaz;\nqux;4.0\n",
+			opts:   &Options{},
+			offset: 16,
+		},
+		"header": {
+			input:  "station;value\nfoo;1.0\nbar;2.0\nbaz;+3.0\nqux;4.0\n",
+			opts:   &Options{Header: true},
+			offset: 30,
+		},
+		"strict": {
+			input:  "foo;1.0\nbar;2.0\nbaz;3.25\nqux;4.0\n",
+			opts:   &Options{Strict: true},
+			offset: 16,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.CreateTemp("", "")
+			if err != nil {
+				t.Fatalf("unable to create temporary file: %v", err)
+			}
+			defer os.Remove(f.Name())
+			if _, err = f.WriteString(tc.input); err != nil {
+				t.Fatalf("unable to write temporary file: %v", err)
+			}
+			f.Close()
+
+			for _, size := range []int{6, 16, chunkSize} {
+				var perr *ParseError
+				_, err := processFile(strings.NewReader(tc.input), size, tc.opts)
+				if !errors.As(err, &perr) {
+					t.Fatalf("processFile(%d): expected ParseError, got %v", size, err)
+				}
+				if diff := cmp.Diff(tc.offset, perr.Offset); diff != "" {
+					t.Errorf("processFile(%d): unexpected offset (-want, +got):\n%s", size, diff)
+				}
+
+				_, err = processFileRandom(f.Name(), size, tc.opts)
+				if !errors.As(err, &perr) {
+					t.Fatalf("processFileRandom(%d): expected ParseError, got %v", size, err)
+				}
+				if diff := cmp.Diff(tc.offset, perr.Offset); diff != "" {
+					t.Errorf("processFileRandom(%d): unexpected offset (-want, +got):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_parser_defaultPath(t *testing.T) {
+	t.Parallel()
+
+	paths, err := filepath.Glob("test/*.txt")
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+
+	for _, opts := range []*Options{
+		{},
+		{Delim: ';', Decimal: '.'},
+		{Delim: ';', Decimal: '.', MaxLineLength: 1024},
+	} {
+		if !newParser(opts).fast {
+			t.Errorf("%+v: expected fast path", opts)
+		}
+		for _, path := range paths {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			want, err := processChunk(b)
+			if err != nil {
+				t.Fatalf("processChunk(%q): %v", path, err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer f.Close()
+			got, err := processFile(f, chunkSize, opts)
+			if err != nil {
+				t.Fatalf("processFile(%q): %v", path, err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%q: unexpected result (-want, +got):\n%s", path, diff)
+			}
+		}
+	}
+}
+
+func Test_parser_collectFastPath(t *testing.T) {
+	t.Parallel()
+
+	p := newParser(&Options{})
+	p.collect = true
+	p.maxViolations = 10
+	if !p.fast {
+		t.Fatalf("expected fast path")
+	}
+
+	got, err := p.processChunk([]byte("foo;1.0\nbar;x\nfoo;3.0\nbaz\n"), 100)
+	if err != nil {
+		t.Fatalf("processChunk: %v", err)
+	}
+	want := map[string]*TempInfo{
+		"foo": {Min: 10, Max: 30, Sum: 40, Count: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+	var offsets []int64
+	for _, v := range p.violations {
+		offsets = append(offsets, v.Offset)
+	}
+	if diff := cmp.Diff([]int64{108, 122}, offsets); diff != "" {
+		t.Fatalf("unexpected violation offsets (-want, +got):\n%s", diff)
+	}
+}
+
+func Test_parseSeparator(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s   string
+		sep byte
+		err bool
+	}{
+		"semicolon": {
+			s:   ";",
+			sep: ';',
+		},
+		"tab escape": {
+			s:   `\t`,
+			sep: '\t',
+		},
+		"tab": {
+			s:   "\t",
+			sep: '\t',
+		},
+		"empty": {
+			s:   "",
+			err: true,
+		},
+		"multiple bytes": {
+			s:   "ab",
+			err: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			sep, err := parseSeparator(tc.s)
+			if got := err != nil; got != tc.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.sep, sep); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOptions_validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		opts *Options
+		err  bool
+	}{
+		"default": {
+			opts: &Options{},
+		},
+		"comma decimal": {
+			opts: &Options{Decimal: ','},
+		},
+		"same separators": {
+			opts: &Options{Delim: ',', Decimal: ','},
+			err:  true,
+		},
+		"newline delimiter": {
+			opts: &Options{Delim: '\n'},
+			err:  true,
+		},
+		"digit decimal": {
+			opts: &Options{Decimal: '0'},
+			err:  true,
+		},
+		"csv format": {
+			opts: &Options{Format: formatCSV},
+		},
+		"unknown format": {
+			opts: &Options{Format: "xml"},
+			err:  true,
+		},
+		"window": {
+			opts: &Options{Window: time.Hour},
+		},
+		"fractional window": {
+			opts: &Options{Window: 1500 * time.Millisecond},
+			err:  true,
+		},
+		"strict window": {
+			opts: &Options{Window: time.Hour, Strict: true},
+			err:  true,
+		},
+		"columns": {
+			opts: &Options{Columns: []string{"temperature", "humidity"}},
+		},
+		"empty column name": {
+			opts: &Options{Columns: []string{"temperature", ""}},
+			err:  true,
+		},
+		"strict columns": {
+			opts: &Options{Columns: []string{"temperature", "humidity"}, Strict: true},
+			err:  true,
+		},
+		"match": {
+			opts: &Options{Match: "^Ha"},
+		},
+		"invalid match": {
+			opts: &Options{Match: "("},
+			err:  true,
+		},
+		"sort": {
+			opts: &Options{Sort: sortRange, Desc: true, Top: 10},
+		},
+		"unknown sort": {
+			opts: &Options{Sort: "median"},
+			err:  true,
+		},
+		"normalize": {
+			opts: &Options{Normalize: normalizeNFKC, FoldCase: true, Collate: "de"},
+		},
+		"unknown normalize": {
+			opts: &Options{Normalize: "nfd"},
+			err:  true,
+		},
+		"invalid collation": {
+			opts: &Options{Collate: "not a tag"},
+			err:  true,
+		},
+		"group by without metadata": {
+			opts: &Options{GroupBy: "country"},
+			err:  true,
+		},
+		"unknown metadata column": {
+			opts: &Options{Metadata: &Metadata{Columns: []string{"country"}}, Where: map[string]string{"region": "NS"}},
+			err:  true,
+		},
+		"group by with groups": {
+			opts: &Options{Metadata: &Metadata{Columns: []string{"country"}}, GroupBy: "country", Groups: map[string]string{}},
+			err:  true,
+		},
+		"negative top": {
+			opts: &Options{Top: -1},
+			err:  true,
+		},
+		"sort with memory budget": {
+			opts: &Options{Sort: sortMax, MemoryBudget: 1 << 20},
+			err:  true,
+		},
+		"top sort with memory budget": {
+			opts: &Options{Sort: sortMax, MemoryBudget: 1 << 20, Top: 10},
+		},
+		"fahrenheit": {
+			opts: &Options{Unit: unitFahrenheit},
+		},
+		"unknown unit": {
+			opts: &Options{Unit: "R"},
+			err:  true,
+		},
+		"strict unit suffix": {
+			opts: &Options{Strict: true, UnitSuffix: true},
+			err:  true,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.opts.validate()
+			if got := err != nil; got != tc.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_parseDecimal(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s       string
+		decimal byte
+		scale   int
+		n       int
+		err     error
+	}{
+		"one digit": {
+			s:     "5.3",
+			scale: 1,
+			n:     53,
+		},
+		"negative": {
+			s:     "-15.2",
+			scale: 1,
+			n:     -152,
+		},
+		"integer": {
+			s:     "3",
+			scale: 1,
+			n:     30,
+		},
+		"fewer digits than scale": {
+			s:     "1.5",
+			scale: 3,
+			n:     1500,
+		},
+		"more digits than scale": {
+			s:     "1.25",
+			scale: 1,
+			n:     13,
+		},
+		"more digits than scale round down": {
+			s:     "1.249",
+			scale: 1,
+			n:     12,
+		},
+		"negative round away from zero": {
+			s:     "-1.25",
+			scale: 1,
+			n:     -13,
+		},
+		"round carry": {
+			s:     "9.99",
+			scale: 1,
+			n:     100,
+		},
+		"decimal comma": {
+			s:       "-0,05",
+			decimal: ',',
+			scale:   2,
+			n:       -5,
+		},
+		"multiple decimal points": {
+			s:     "1.2.3",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"sign only": {
+			s:     "-",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"plus sign": {
+			s:     "+5",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"empty": {
+			s:     "",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"no integer digits": {
+			s:     ".5",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"no fractional digits": {
+			s:     "5.",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"wrong decimal separator": {
+			s:       "5.0",
+			decimal: ',',
+			scale:   1,
+			err:     errInputFormat,
+		},
+		"carriage return": {
+			s:     "5.0\r",
+			scale: 1,
+			err:   errInputFormat,
+		},
+		"too many digits": {
+			s:     "12345678901234567890",
+			scale: 1,
+			err:   errInputFormat,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			decimal := tc.decimal
+			if decimal == 0 {
+				decimal = '.'
+			}
+			n, err := parseDecimal(tc.s, decimal, tc.scale)
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.n, n); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// partialMagic identifies the binary partial result format.
+	partialMagic = "1BRCPART"
+
+	// partialVersion is the current version of the partial result formats.
+	// Version 1 partial results have no window, versions 1 and 2 have a
+	// single value column, and versions before 4 have no unit suffix flag.
+	partialVersion = 4
+
+	formatBinary = "binary"
+	formatJSON   = "json"
+)
+
+var (
+	errPartialFormat = errors.New("bad partial result format")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// partial is a partial result for some portion of the input that can be
+// merged with other partial results.
+type partial struct {
+	// Scale is the number of fractional digits in values.
+	Scale int
+
+	// UnitSuffix indicates that values were parsed with unit suffixes and
+	// have two more fractional digits than results are written with. See
+	// Options.valueScale.
+	UnitSuffix bool
+
+	// Window is the size of the windows if the station names are windowed
+	// keys.
+	Window time.Duration
+
+	// Columns names the value columns if there is more than one.
+	Columns []string
+
+	Stations map[string]*TempInfo
+}
+
+// newPartial returns a partial result for the stations in m produced with the
+// given options. If m is nil an empty map is used.
+func newPartial(opts *Options, m map[string]*TempInfo) *partial {
+	if m == nil {
+		m = make(map[string]*TempInfo, maxCities)
+	}
+	return &partial{
+		Scale:      opts.valueScale(),
+		UnitSuffix: opts.UnitSuffix,
+		Window:     opts.Window,
+		Columns:    opts.Columns,
+		Stations:   m,
+	}
+}
+
+// outputScale returns the number of fractional digits results are written
+// with.
+func (p *partial) outputScale() int {
+	if p.UnitSuffix {
+		return p.Scale - 2
+	}
+	return p.Scale
+}
+
+// options sets the options that describe the values in p, so that opts
+// writes the results with the precision they were produced with.
+func (p *partial) options(opts *Options) {
+	opts.Scale = p.outputScale()
+	opts.UnitSuffix = p.UnitSuffix
+	opts.Window = p.Window
+	opts.Columns = p.Columns
+}
+
+// merge merges o into p.
+func (p *partial) merge(o *partial) error {
+	if p.Scale != o.Scale {
+		return fmt.Errorf("%w: cannot merge scale %d with scale %d", errPartialFormat, o.Scale, p.Scale)
+	}
+	if p.UnitSuffix != o.UnitSuffix {
+		return fmt.Errorf("%w: cannot merge results with and without unit suffixes", errPartialFormat)
+	}
+	if p.Window != o.Window {
+		return fmt.Errorf("%w: cannot merge window %v with window %v", errPartialFormat, o.Window, p.Window)
+	}
+	if !slices.Equal(p.Columns, o.Columns) {
+		return fmt.Errorf("%w: cannot merge columns %q with columns %q", errPartialFormat, o.Columns, p.Columns)
+	}
+	mergeMap(p.Stations, o.Stations)
+	return nil
+}
+
+// sortedKeys returns the station names in the partial in sorted order.
+func (p *partial) sortedKeys() []string {
+	keys := make([]string, 0, len(p.Stations))
+	for k := range p.Stations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendRecords appends the binary encoding of the stations to b in sorted
+// order using the record format of the given version.
+func (p *partial) appendRecords(b []byte, version int) []byte {
+	for _, k := range p.sortedKeys() {
+		if version < 3 {
+			b = appendBaseRecord(b, k, p.Stations[k])
+		} else {
+			b = appendRecord(b, k, p.Stations[k])
+		}
+	}
+	return b
+}
+
+// stationJSON is the JSON encoding of a station in a partial result.
+type stationJSON struct {
+	Name  string `json:"name"`
+	Min   int    `json:"min"`
+	Max   int    `json:"max"`
+	Sum   int    `json:"sum"`
+	Count int    `json:"count"`
+
+	// Extra holds the stats for the value columns after the first.
+	Extra []columnJSON `json:"extra,omitempty"`
+}
+
+// columnJSON is the JSON encoding of the stats for a value column.
+type columnJSON struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Sum   int `json:"sum"`
+	Count int `json:"count"`
+}
+
+// partialJSON is the JSON encoding of a partial result. The checksum is the
+// CRC-32C of the binary encoding of the stations.
+type partialJSON struct {
+	Version    int           `json:"version"`
+	Scale      int           `json:"scale"`
+	UnitSuffix bool          `json:"unit_suffix,omitempty"`
+	Window     int64         `json:"window,omitempty"`
+	Columns    []string      `json:"columns,omitempty"`
+	Checksum   string        `json:"checksum"`
+	Stations   []stationJSON `json:"stations"`
+}
+
+// writePartial writes p to w in the given format.
+//
+// The binary format is the magic string "1BRCPART", a big-endian uint16
+// version, the scale as a single byte, the window in seconds as a uvarint, the
+// number of column names as a uvarint followed by each name as a uvarint
+// length and bytes, a byte that is 1 if values were parsed with unit suffixes
+// and 0 otherwise, the number of stations as a uvarint, records for each
+// station sorted by name, and a big-endian CRC-32C of all preceding bytes. The
+// window in the JSON format is also in seconds.
+func writePartial(w io.Writer, p *partial, format string) error {
+	switch format {
+	case formatBinary, "":
+		b := []byte(partialMagic)
+		b = binary.BigEndian.AppendUint16(b, partialVersion)
+		b = append(b, byte(p.Scale))
+		b = binary.AppendUvarint(b, uint64(p.Window/time.Second))
+		b = binary.AppendUvarint(b, uint64(len(p.Columns)))
+		for _, c := range p.Columns {
+			b = binary.AppendUvarint(b, uint64(len(c)))
+			b = append(b, c...)
+		}
+		var suffix byte
+		if p.UnitSuffix {
+			suffix = 1
+		}
+		b = append(b, suffix)
+		b = binary.AppendUvarint(b, uint64(len(p.Stations)))
+		b = p.appendRecords(b, partialVersion)
+		b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, crcTable))
+		_, err := w.Write(b)
+		return err
+
+	case formatJSON:
+		pj := partialJSON{
+			Version:    partialVersion,
+			Scale:      p.Scale,
+			UnitSuffix: p.UnitSuffix,
+			Window:     int64(p.Window / time.Second),
+			Columns:    p.Columns,
+			Checksum:   checksumHex(p.appendRecords(nil, partialVersion)),
+			Stations:   make([]stationJSON, 0, len(p.Stations)),
+		}
+		for _, k := range p.sortedKeys() {
+			info := p.Stations[k]
+			sj := stationJSON{
+				Name:  k,
+				Min:   info.Min,
+				Max:   info.Max,
+				Sum:   info.Sum,
+				Count: info.Count,
+			}
+			for _, e := range info.Extra {
+				sj.Extra = append(sj.Extra, columnJSON{
+					Min:   e.Min,
+					Max:   e.Max,
+					Sum:   e.Sum,
+					Count: e.Count,
+				})
+			}
+			pj.Stations = append(pj.Stations, sj)
+		}
+		return json.NewEncoder(w).Encode(pj)
+
+	default:
+		return fmt.Errorf("unknown partial result format %q", format)
+	}
+}
+
+// readPartial reads a partial result in either the binary or JSON format.
+func readPartial(r io.Reader) (*partial, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimLeft(b, " \t\r\n"); len(t) > 0 && t[0] == '{' {
+		return decodePartialJSON(t)
+	}
+	return decodePartial(b)
+}
+
+// decodePartial decodes a partial result in the binary format.
+func decodePartial(b []byte) (*partial, error) {
+	const headerLen = len(partialMagic) + 3
+	if len(b) < headerLen+4 || string(b[:len(partialMagic)]) != partialMagic {
+		return nil, fmt.Errorf("%w: missing header", errPartialFormat)
+	}
+	v := binary.BigEndian.Uint16(b[len(partialMagic):])
+	if v < 1 || v > partialVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errPartialFormat, v)
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.Checksum(body, crcTable) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errPartialFormat)
+	}
+
+	p := &partial{
+		Scale: int(b[headerLen-1]),
+	}
+	r := bufio.NewReader(bytes.NewReader(body[headerLen:]))
+	if v >= 2 {
+		window, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errPartialFormat, noEOF(err))
+		}
+		p.Window = time.Duration(window) * time.Second
+	}
+	if v >= 3 {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errPartialFormat, noEOF(err))
+		}
+		if n > maxRecordColumns {
+			return nil, fmt.Errorf("%w: too many columns", errPartialFormat)
+		}
+		for i := uint64(0); i < n; i++ {
+			c, err := readString(r)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errPartialFormat, err)
+			}
+			p.Columns = append(p.Columns, c)
+		}
+	}
+	if v >= 4 {
+		suffix, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errPartialFormat, noEOF(err))
+		}
+		if suffix > 1 {
+			return nil, fmt.Errorf("%w: invalid unit suffix flag %d", errPartialFormat, suffix)
+		}
+		p.UnitSuffix = suffix == 1
+	}
+	if err := p.checkScale(); err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errPartialFormat, noEOF(err))
+	}
+	p.Stations = make(map[string]*TempInfo, min(n, maxCities))
+	for i := uint64(0); i < n; i++ {
+		name, info, err := readRecord(r, v >= 3)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errPartialFormat, noEOF(err))
+		}
+		if _, ok := p.Stations[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate station %q", errPartialFormat, name)
+		}
+		p.Stations[name] = info
+	}
+	if r.Buffered() > 0 {
+		return nil, fmt.Errorf("%w: trailing data", errPartialFormat)
+	}
+	return p, nil
+}
+
+// decodePartialJSON decodes a partial result in the JSON format.
+func decodePartialJSON(b []byte) (*partial, error) {
+	var pj partialJSON
+	if err := json.Unmarshal(b, &pj); err != nil {
+		return nil, fmt.Errorf("%w: %w", errPartialFormat, err)
+	}
+	if pj.Version < 1 || pj.Version > partialVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errPartialFormat, pj.Version)
+	}
+
+	p := &partial{
+		Scale:      pj.Scale,
+		UnitSuffix: pj.UnitSuffix,
+		Window:     time.Duration(pj.Window) * time.Second,
+		Columns:    pj.Columns,
+		Stations:   make(map[string]*TempInfo, len(pj.Stations)),
+	}
+	if err := p.checkScale(); err != nil {
+		return nil, err
+	}
+	for _, s := range pj.Stations {
+		info := &TempInfo{
+			Min:   s.Min,
+			Max:   s.Max,
+			Sum:   s.Sum,
+			Count: s.Count,
+		}
+		for _, e := range s.Extra {
+			info.Extra = append(info.Extra, TempInfo{
+				Min:   e.Min,
+				Max:   e.Max,
+				Sum:   e.Sum,
+				Count: e.Count,
+			})
+		}
+		if _, ok := p.Stations[s.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate station %q", errPartialFormat, s.Name)
+		}
+		p.Stations[s.Name] = info
+	}
+	if checksumHex(p.appendRecords(nil, pj.Version)) != pj.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errPartialFormat)
+	}
+	return p, nil
+}
+
+// checkScale returns an error if the scale of the decoded partial result is
+// out of range.
+func (p *partial) checkScale() error {
+	if s := p.outputScale(); s < 1 || s > maxScale {
+		return fmt.Errorf("%w: invalid scale %d", errPartialFormat, p.Scale)
+	}
+	return nil
+}
+
+// readString reads a string written as a uvarint length followed by its bytes.
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", noEOF(err)
+	}
+	if n > maxRecordName {
+		return "", errors.New("string too long")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", noEOF(err)
+	}
+	return string(b), nil
+}
+
+// checksumHex returns the hex encoded CRC-32C of b.
+func checksumHex(b []byte) string {
+	return hex.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.Checksum(b, crcTable)))
+}
+
+// readPartialFile reads a partial result from the file at path.
+func readPartialFile(path string) (*partial, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := readPartial(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// writePartialFile writes p to the file at path in the given format. If path
+// is "-" the partial result is written to stdout.
+func writePartialFile(path string, p *partial, format string) error {
+	if path == "-" {
+		return writePartial(os.Stdout, p, format)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writePartial(f, p, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// mergePartials reads the partial results at the given paths and merges them
+// into a single result.
+func mergePartials(paths []string) (*partial, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("merge: no input files")
+	}
+
+	var result *partial
+	for _, path := range paths {
+		p, err := readPartialFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = p
+			continue
+		}
+		if err := result.merge(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_writePartial(t *testing.T) {
+	t.Parallel()
+
+	p := &partial{
+		Scale: 2,
+		Stations: map[string]*TempInfo{
+			"Halifax": {
+				Min:   -310,
+				Max:   1290,
+				Sum:   980,
+				Count: 2,
+			},
+			"Zagreb": {
+				Min:   1220,
+				Max:   1220,
+				Sum:   1220,
+				Count: 1,
+			},
+			"": {
+				Min:   0,
+				Max:   0,
+				Sum:   0,
+				Count: 1,
+			},
+		},
+	}
+
+	for _, format := range []string{formatBinary, formatJSON} {
+		var b bytes.Buffer
+		if err := writePartial(&b, p, format); err != nil {
+			t.Fatalf("writePartial(%q): %v", format, err)
+		}
+		got, err := readPartial(&b)
+		if err != nil {
+			t.Fatalf("readPartial(%q): %v", format, err)
+		}
+		if diff := cmp.Diff(p, got); diff != "" {
+			t.Fatalf("%q: unexpected result (-want, +got):\n%s", format, diff)
+		}
+	}
+
+	if err := writePartial(&bytes.Buffer{}, p, "xml"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
+
+func Test_writePartial_window(t *testing.T) {
+	t.Parallel()
+
+	p := &partial{
+		Scale:  1,
+		Window: time.Hour,
+		Stations: map[string]*TempInfo{
+			"2026-10-17T12:00:00ZHalifax": {
+				Min:   -31,
+				Max:   129,
+				Sum:   98,
+				Count: 2,
+			},
+		},
+	}
+
+	for _, format := range []string{formatBinary, formatJSON} {
+		var b bytes.Buffer
+		if err := writePartial(&b, p, format); err != nil {
+			t.Fatalf("writePartial(%q): %v", format, err)
+		}
+		got, err := readPartial(&b)
+		if err != nil {
+			t.Fatalf("readPartial(%q): %v", format, err)
+		}
+		if diff := cmp.Diff(p, got); diff != "" {
+			t.Fatalf("%q: unexpected result (-want, +got):\n%s", format, diff)
+		}
+	}
+
+	unwindowed := &partial{Scale: 1, Stations: map[string]*TempInfo{}}
+	if err := unwindowed.merge(p); !cmp.Equal(errPartialFormat, err, cmpopts.EquateErrors()) {
+		t.Fatalf("merge: want %v, got %v", errPartialFormat, err)
+	}
+}
+
+func Test_writePartial_columns(t *testing.T) {
+	t.Parallel()
+
+	p := &partial{
+		Scale:   1,
+		Columns: []string{"temperature", "humidity"},
+		Stations: map[string]*TempInfo{
+			"Halifax": {
+				Min:   -31,
+				Max:   129,
+				Sum:   98,
+				Count: 2,
+				Extra: []TempInfo{
+					{Min: 400, Max: 700, Sum: 1100, Count: 2},
+				},
+			},
+			"Zagreb": {
+				Min:   122,
+				Max:   122,
+				Sum:   122,
+				Count: 1,
+				Extra: []TempInfo{{}},
+			},
+		},
+	}
+
+	for _, format := range []string{formatBinary, formatJSON} {
+		var b bytes.Buffer
+		if err := writePartial(&b, p, format); err != nil {
+			t.Fatalf("writePartial(%q): %v", format, err)
+		}
+		got, err := readPartial(&b)
+		if err != nil {
+			t.Fatalf("readPartial(%q): %v", format, err)
+		}
+		if diff := cmp.Diff(p, got); diff != "" {
+			t.Fatalf("%q: unexpected result (-want, +got):\n%s", format, diff)
+		}
+	}
+
+	other := &partial{Scale: 1, Columns: []string{"temperature", "pressure"}, Stations: map[string]*TempInfo{}}
+	if err := other.merge(p); !cmp.Equal(errPartialFormat, err, cmpopts.EquateErrors()) {
+		t.Fatalf("merge: want %v, got %v", errPartialFormat, err)
+	}
+}
+
+func Test_writePartial_unitSuffix(t *testing.T) {
+	t.Parallel()
+
+	p := &partial{
+		Scale:      3,
+		UnitSuffix: true,
+		Stations: map[string]*TempInfo{
+			"Halifax": {
+				Min:   -12222,
+				Max:   129000,
+				Sum:   116778,
+				Count: 2,
+			},
+		},
+	}
+
+	for _, format := range []string{formatBinary, formatJSON} {
+		var b bytes.Buffer
+		if err := writePartial(&b, p, format); err != nil {
+			t.Fatalf("writePartial(%q): %v", format, err)
+		}
+		got, err := readPartial(&b)
+		if err != nil {
+			t.Fatalf("readPartial(%q): %v", format, err)
+		}
+		if diff := cmp.Diff(p, got); diff != "" {
+			t.Fatalf("%q: unexpected result (-want, +got):\n%s", format, diff)
+		}
+	}
+
+	var opts Options
+	p.options(&opts)
+	if diff := cmp.Diff(Options{Scale: 1, UnitSuffix: true}, opts); diff != "" {
+		t.Fatalf("unexpected options (-want, +got):\n%s", diff)
+	}
+
+	// A scale of 3 without unit suffixes has no extra digits.
+	other := &partial{Scale: 3, Stations: map[string]*TempInfo{}}
+	if err := other.merge(p); !cmp.Equal(errPartialFormat, err, cmpopts.EquateErrors()) {
+		t.Fatalf("merge: want %v, got %v", errPartialFormat, err)
+	}
+}
+
+func Test_readPartial_version1(t *testing.T) {
+	t.Parallel()
+
+	// Version 1 partial results have no window.
+	b := []byte(partialMagic)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = append(b, 1)
+	b = binary.AppendUvarint(b, 1)
+	b = appendBaseRecord(b, "Halifax", &TempInfo{Min: -31, Max: 129, Sum: 98, Count: 2})
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, crcTable))
+
+	got, err := readPartial(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("readPartial: %v", err)
+	}
+	want := &partial{
+		Scale: 1,
+		Stations: map[string]*TempInfo{
+			"Halifax": {Min: -31, Max: 129, Sum: 98, Count: 2},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+}
+
+func Test_readPartial_errors(t *testing.T) {
+	t.Parallel()
+
+	p := &partial{
+		Scale: 1,
+		Stations: map[string]*TempInfo{
+			"Halifax": {
+				Min:   -31,
+				Max:   129,
+				Sum:   98,
+				Count: 2,
+			},
+		},
+	}
+	var bin, js bytes.Buffer
+	if err := writePartial(&bin, p, formatBinary); err != nil {
+		t.Fatalf("writePartial: %v", err)
+	}
+	if err := writePartial(&js, p, formatJSON); err != nil {
+		t.Fatalf("writePartial: %v", err)
+	}
+
+	corrupt := bytes.Clone(bin.Bytes())
+	corrupt[len(partialMagic)+5] ^= 0xff
+
+	version := bytes.Clone(bin.Bytes())
+	version[len(partialMagic)+1] = partialVersion + 1
+
+	var scale bytes.Buffer
+	if err := writePartial(&scale, &partial{Scale: maxValueScale + 1}, formatBinary); err != nil {
+		t.Fatalf("writePartial: %v", err)
+	}
+
+	var suffixScale bytes.Buffer
+	if err := writePartial(&suffixScale, &partial{Scale: 2, UnitSuffix: true}, formatBinary); err != nil {
+		t.Fatalf("writePartial: %v", err)
+	}
+
+	// The unit suffix flag follows the window and the number of columns.
+	suffix := bytes.Clone(bin.Bytes()[:bin.Len()-4])
+	suffix[len(partialMagic)+5] = 2
+	suffix = binary.BigEndian.AppendUint32(suffix, crc32.Checksum(suffix, crcTable))
+
+	// Partial results are written without duplicate stations.
+	dup := []byte(partialMagic)
+	dup = binary.BigEndian.AppendUint16(dup, 1)
+	dup = append(dup, 1)
+	dup = binary.AppendUvarint(dup, 2)
+	dup = appendBaseRecord(dup, "Halifax", &TempInfo{Min: -31, Max: 129, Sum: 98, Count: 2})
+	dup = appendBaseRecord(dup, "Halifax", &TempInfo{Min: 10, Max: 10, Sum: 10, Count: 1})
+	dup = binary.BigEndian.AppendUint32(dup, crc32.Checksum(dup, crcTable))
+	i := strings.Index(js.String(), `"stations":[`) + len(`"stations":[`)
+	j := strings.LastIndex(js.String(), "]")
+	station := js.String()[i:j]
+	jsonDup := js.String()[:i] + station + "," + station + js.String()[j:]
+
+	testCases := map[string][]byte{
+		"empty":                  {},
+		"bad magic":              append([]byte("NOTMAGIC"), bin.Bytes()[len(partialMagic):]...),
+		"bad version":            version,
+		"corrupt":                corrupt,
+		"truncated":              bin.Bytes()[:bin.Len()-1],
+		"json corrupt":           []byte(strings.Replace(js.String(), "129", "130", 1)),
+		"json version":           []byte(strings.Replace(js.String(), `"version":4`, `"version":5`, 1)),
+		"json malformed":         js.Bytes()[:js.Len()-3],
+		"bad scale":              scale.Bytes(),
+		"duplicate":              dup,
+		"json scale":             []byte(strings.Replace(js.String(), `"scale":1`, `"scale":0`, 1)),
+		"json duplicate":         []byte(jsonDup),
+		"bad unit suffix":        suffix,
+		"unit suffix scale":      suffixScale.Bytes(),
+		"json unit suffix scale": []byte(strings.Replace(js.String(), `"scale":1`, `"scale":1,"unit_suffix":true`, 1)),
+	}
+
+	for name, b := range testCases {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := readPartial(bytes.NewReader(b))
+			if diff := cmp.Diff(errPartialFormat, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_mergePartials(t *testing.T) {
+	t.Parallel()
+
+	b, err := os.ReadFile("test/measurements-rounding.txt")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	want, err := processChunk(b)
+	if err != nil {
+		t.Fatalf("processChunk: %v", err)
+	}
+
+	// Split the input into shards at line boundaries and write a partial
+	// result for each.
+	dir := t.TempDir()
+	var paths []string
+	start := 0
+	for i, format := range []string{formatBinary, formatJSON, formatBinary} {
+		end := len(b)
+		if i < 2 {
+			end = len(b) * (i + 1) / 3
+			end += bytes.IndexByte(b[end:], '\n') + 1
+		}
+		m, err := processFile(bytes.NewReader(b[start:end]), chunkSize, nil)
+		if err != nil {
+			t.Fatalf("processFile: %v", err)
+		}
+		start = end
+
+		path := filepath.Join(dir, format+string(rune('0'+i)))
+		if err := writePartialFile(path, &partial{Scale: 1, Stations: m}, format); err != nil {
+			t.Fatalf("writePartialFile: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	p, err := mergePartials(paths)
+	if err != nil {
+		t.Fatalf("mergePartials: %v", err)
+	}
+	if diff := cmp.Diff(&partial{Scale: 1, Stations: want}, p); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	// Partial results with different scales cannot be merged.
+	path := filepath.Join(dir, "scale")
+	if err := writePartialFile(path, &partial{Scale: 2, Stations: want}, formatBinary); err != nil {
+		t.Fatalf("writePartialFile: %v", err)
+	}
+	_, err = mergePartials(append(paths, path))
+	if diff := cmp.Diff(errPartialFormat, err, cmpopts.EquateErrors()); diff != "" {
+		t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+	}
+}
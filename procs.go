@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// procEnv is the environment variable set for child processes started by
+// processFileProcs.
+const procEnv = "ONEBRC_PROC"
+
+// isProcChild returns true if the current process is a child process started by
+// processFileProcs.
+func isProcChild() bool {
+	return os.Getenv(procEnv) == "1"
+}
+
+// procChild reads RangeArgs encoded with gob from r, processes the range and
+// writes the RangeReply encoded with gob to w.
+func procChild(r io.Reader, w io.Writer) error {
+	var args RangeArgs
+	if err := gob.NewDecoder(r).Decode(&args); err != nil {
+		return fmt.Errorf("reading range: %w", err)
+	}
+	var reply RangeReply
+	if err := (&Worker{}).Process(&args, &reply); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(&reply)
+}
+
+// processFileProcs splits the file at path into n byte ranges and processes
+// each range in a child process running the current executable. The children
+// write their replies to a pipe and the partial results are merged into the
+// result.
+func processFileProcs(path string, n int, opts *Options) (map[string]*TempInfo, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	size := (fi.Size() + int64(n) - 1) / int64(n)
+	if size == 0 {
+		size = 1
+	}
+	ranges, err := splitFiles([]string{path}, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share the CPUs between the children.
+	maxProcs := runtime.GOMAXPROCS(0) / len(ranges)
+	if maxProcs < 1 {
+		maxProcs = 1
+	}
+
+	results := make([]*RangeReply, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r fileRange) {
+			defer wg.Done()
+			results[i], errs[i] = runProc(exe, maxProcs, &RangeArgs{
+				Path:    r.path,
+				Offset:  r.offset,
+				Size:    r.size,
+				Options: *opts,
+			})
+		}(i, r)
+	}
+	wg.Wait()
+
+	result := newPartial(opts, nil)
+	for i, reply := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		p, err := readPartial(bytes.NewReader(reply.Partial))
+		if err != nil {
+			return nil, fmt.Errorf("range at offset %d: %w", ranges[i].offset, err)
+		}
+		if err := result.merge(p); err != nil {
+			return nil, err
+		}
+		for _, name := range reply.Missing {
+			opts.Metadata.addMissing(name)
+		}
+	}
+	return result.Stations, checkStationCount(result.Stations, opts)
+}
+
+// runProc runs exe as a child process to process the range given by args and
+// returns its reply.
+func runProc(exe string, maxProcs int, args *RangeArgs) (*RangeReply, error) {
+	var in bytes.Buffer
+	if err := gob.NewEncoder(&in).Encode(args); err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), procEnv+"=1", "GOMAXPROCS="+strconv.Itoa(maxProcs))
+	cmd.Stdin = &in
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var reply RangeReply
+	readErr := gob.NewDecoder(out).Decode(&reply)
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("range at offset %d: %s", args.Offset, msg)
+		}
+		return nil, fmt.Errorf("range at offset %d: %w", args.Offset, err)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("range at offset %d: %w", args.Offset, readErr)
+	}
+	return &reply, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestMain runs the test binary as a child process for processFileProcs when
+// started by it.
+func TestMain(m *testing.M) {
+	if isProcChild() {
+		if err := procChild(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func Test_processFileProcs(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path  string
+		procs int
+		opts  *Options
+	}{
+		"single process": {
+			path:  "test/measurements-10000-unique-keys.txt",
+			procs: 1,
+			opts:  &Options{},
+		},
+		"multiple processes": {
+			path:  "test/measurements-10000-unique-keys.txt",
+			procs: 4,
+			opts:  &Options{},
+		},
+		"more processes than bytes": {
+			path:  "test/measurements-1.txt",
+			procs: 64,
+			opts:  &Options{},
+		},
+		"options": {
+			path:  "test/options/measurements-european.txt",
+			procs: 3,
+			opts:  &Options{Decimal: ',', Header: true},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want, err := processFileRandom(tc.path, segmentSize, tc.opts)
+			if err != nil {
+				t.Fatalf("processFileRandom: %v", err)
+			}
+			got, err := processFileProcs(tc.path, tc.procs, tc.opts)
+			if err != nil {
+				t.Fatalf("processFileProcs: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_processFileProcs_metadata(t *testing.T) {
+	t.Parallel()
+
+	md, err := readMetadata("test/options/metadata.csv")
+	if err != nil {
+		t.Fatalf("readMetadata: %v", err)
+	}
+
+	// Missing stations are found by the children before stations are
+	// grouped.
+	opts := &Options{Metadata: md, GroupBy: "country"}
+	if _, err := processFileProcs("test/measurements-10.txt", 3, opts); err != nil {
+		t.Fatalf("processFileProcs: %v", err)
+	}
+	if diff := cmp.Diff([]string{"Dodoma", "Ségou", "Xi'an"}, missingMetadata(nil, opts)); diff != "" {
+		t.Fatalf("unexpected missing stations (-want, +got):\n%s", diff)
+	}
+}
+
+func Test_processFileProcs_error(t *testing.T) {
+	t.Parallel()
+
+	_, err := processFileProcs("test/options/measurements-invalid.txt", 2, &Options{})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
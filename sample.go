@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// defaultConfidence is the default confidence level of sampled estimates.
+const defaultConfidence = 0.95
+
+// clusterStats holds the stats for a station's values in the sampled
+// segments. The segments are treated as clusters so that means can be
+// estimated with the ratio estimator. For each segment x is the number of
+// values and y is their sum.
+type clusterStats struct {
+	info TempInfo
+
+	// segments is the number of sampled segments with values for the
+	// station.
+	segments int
+
+	// xx, xy and yy are the sums over the sampled segments of x*x, x*y
+	// and y*y. Segments without values for the station add nothing.
+	xx, xy, yy float64
+}
+
+// add adds the stats for the station's values in a segment.
+func (s *clusterStats) add(info *TempInfo) {
+	x, y := float64(info.Count), float64(info.Sum)
+	s.info.merge(info)
+	s.segments++
+	s.xx += x * x
+	s.xy += x * y
+	s.yy += y * y
+}
+
+// merge merges o into s.
+func (s *clusterStats) merge(o *clusterStats) {
+	s.info.merge(&o.info)
+	s.segments += o.segments
+	s.xx += o.xx
+	s.xy += o.xy
+	s.yy += o.yy
+}
+
+// sampleResult is the result of processing a random sample of the segments of
+// a file.
+type sampleResult struct {
+	// segments is the number of segments in the file and sampled is the
+	// number of segments that were processed.
+	segments, sampled int
+
+	// confidence is the confidence level of the estimates.
+	confidence float64
+
+	stations map[string]*clusterStats
+}
+
+// z returns the two-sided critical value of the standard normal distribution
+// for the confidence level.
+func (r *sampleResult) z() float64 {
+	return math.Sqrt2 * math.Erfinv(r.confidence)
+}
+
+// t returns the two-sided critical value of Student's t distribution with df
+// degrees of freedom for the confidence level. It uses the expansion in
+// Abramowitz and Stegun 26.7.5.
+func (r *sampleResult) t(df float64) float64 {
+	z := r.z()
+	z3, z5, z7 := z*z*z, math.Pow(z, 5), math.Pow(z, 7)
+	return z +
+		(z3+z)/(4*df) +
+		(5*z5+16*z3+3*z)/(96*df*df) +
+		(3*z7+19*z5+17*z3-15*z)/(384*df*df*df)
+}
+
+// infos returns the stats for the sampled values of each station.
+func (r *sampleResult) infos() map[string]*TempInfo {
+	m := make(map[string]*TempInfo, len(r.stations))
+	for name, s := range r.stations {
+		m[name] = &s.info
+	}
+	return m
+}
+
+// estimate returns the estimated mean of the station's values and the margin
+// of error of the mean at the confidence level, both in units of the values,
+// along with the estimated number of values in the file. The margin of error
+// is infinite if it cannot be estimated because fewer than two sampled
+// segments have values for the station.
+func (r *sampleResult) estimate(name string) (mean, margin, count float64) {
+	s := r.stations[name]
+	n, total := float64(r.sampled), float64(r.segments)
+	x, y := float64(s.info.Count), float64(s.info.Sum)
+	mean = y / x
+	count = x * total / n
+	switch {
+	case r.sampled >= r.segments:
+		return mean, 0, count
+	case s.segments < 2:
+		return mean, math.Inf(1), count
+	}
+
+	// The variance of the ratio estimator with a finite population
+	// correction.
+	xbar := x / n
+	s2 := (s.yy - 2*mean*s.xy + mean*mean*s.xx) / (n - 1)
+	v := (1 - n/total) * math.Max(s2, 0) / (n * xbar * xbar)
+	return mean, r.t(float64(s.segments-1)) * math.Sqrt(v), count
+}
+
+// stationCount returns the number of stations seen in the sample and the
+// estimated number of stations in the file with its confidence interval. The
+// estimate is the bias-corrected Chao1 estimator and the interval is the
+// log-normal interval of Chao (1987).
+func (r *sampleResult) stationCount() (observed int, estimate, low, high float64) {
+	var f1, f2 float64
+	for _, s := range r.stations {
+		switch s.info.Count {
+		case 1:
+			f1++
+		case 2:
+			f2++
+		}
+	}
+	observed = len(r.stations)
+	sobs := float64(observed)
+	if r.sampled >= r.segments || f1 == 0 {
+		return observed, sobs, sobs, sobs
+	}
+
+	unseen := f1 * (f1 - 1) / (2 * (f2 + 1))
+	if unseen == 0 {
+		return observed, sobs, sobs, sobs
+	}
+	v := unseen +
+		f1*(2*f1-1)*(2*f1-1)/(4*(f2+1)*(f2+1)) +
+		f1*f1*f2*(f1-1)*(f1-1)/(4*math.Pow(f2+1, 4))
+	k := math.Exp(r.z() * math.Sqrt(math.Log(1+v/(unseen*unseen))))
+	return observed, sobs + unseen, sobs + unseen/k, sobs + unseen*k
+}
+
+// sampleOffsets returns the offsets of a random sample of the segments of
+// size in a file of the given length. At least two segments are sampled so
+// that the variance can be estimated, unless the file has fewer segments.
+func sampleOffsets(length int64, size int, fraction float64, rng *rand.Rand) (offsets []int64, segments int) {
+	segments = int((length + int64(size) - 1) / int64(size))
+	n := int(math.Ceil(fraction * float64(segments)))
+	if n < 2 {
+		n = 2
+	}
+	if n > segments {
+		n = segments
+	}
+	for _, i := range rng.Perm(segments)[:n] {
+		offsets = append(offsets, int64(i)*int64(size))
+	}
+	return offsets, segments
+}
+
+// processFileSample reads a random fraction of the segments of size in the
+// file at path, chosen using seed, and returns the sampled results.
+func processFileSample(path string, size int, fraction, confidence float64, seed int64, opts *Options) (*sampleResult, error) {
+	switch {
+	case fraction <= 0 || fraction > 1:
+		return nil, fmt.Errorf("invalid sample fraction: %v", fraction)
+	case confidence <= 0 || confidence >= 1:
+		return nil, fmt.Errorf("invalid confidence: %v", confidence)
+	case opts.Window > 0:
+		return nil, errors.New("windowed results cannot be sampled")
+	case len(opts.Columns) > 1:
+		return nil, errors.New("multiple value columns cannot be sampled")
+	case opts.unit() != unitCelsius:
+		return nil, errors.New("sampled results can only be estimated in Celsius")
+	}
+
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	offsets, segments := sampleOffsets(int64(len(data)), size, fraction, rand.New(rand.NewSource(seed)))
+
+	parsers := make([]*parser, runtime.GOMAXPROCS(0))
+	results := make([]map[string]*clusterStats, len(parsers))
+	for i := range parsers {
+		parsers[i] = newParser(opts)
+		results[i] = make(map[string]*clusterStats)
+	}
+	var cursor atomic.Int64
+	next := func() (int64, bool) {
+		i := cursor.Add(1) - 1
+		if i >= int64(len(offsets)) {
+			return 0, false
+		}
+		return offsets[i], true
+	}
+	err = scanSegments(data, size, parsers, next, func(i int, chunk []byte, offset int64) error {
+		m, err := parsers[i].processChunk(chunk, offset)
+		if err != nil {
+			return err
+		}
+		for name, info := range m {
+			s, ok := results[i][name]
+			if !ok {
+				s = &clusterStats{}
+				results[i][name] = s
+			}
+			s.add(info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := results[0]
+	for _, m := range results[1:] {
+		for name, s := range m {
+			if t, ok := merged[name]; ok {
+				t.merge(s)
+			} else {
+				merged[name] = s
+			}
+		}
+	}
+	return &sampleResult{
+		segments:   segments,
+		sampled:    len(offsets),
+		confidence: confidence,
+		stations:   merged,
+	}, nil
+}
+
+// estimateJSON is the JSON encoding of the estimated result for a station.
+type estimateJSON struct {
+	Name string      `json:"name"`
+	Min  json.Number `json:"min"`
+	Mean json.Number `json:"mean"`
+	Max  json.Number `json:"max"`
+
+	// Margin is the margin of error of Mean at the confidence level. It is
+	// empty if the margin of error cannot be estimated.
+	Margin json.Number `json:"margin,omitempty"`
+
+	// Count is the number of sampled values and EstimatedCount is the
+	// estimated number of values in the file.
+	Count          int `json:"count"`
+	EstimatedCount int `json:"estimated_count"`
+}
+
+// sampleJSON is the JSON encoding of a sampleResult.
+type sampleJSON struct {
+	Segments          int            `json:"segments"`
+	Sampled           int            `json:"sampled"`
+	Confidence        float64        `json:"confidence"`
+	ObservedStations  int            `json:"observed_stations"`
+	EstimatedStations float64        `json:"estimated_stations"`
+	StationsLow       float64        `json:"estimated_stations_low"`
+	StationsHigh      float64        `json:"estimated_stations_high"`
+	Stations          []estimateJSON `json:"stations"`
+}
+
+// writeSample writes the estimated results to w in the format given by opts.
+// Margins of error are rounded up to opts.Scale fractional digits and are
+// omitted if they cannot be estimated. The
+// estimated number of stations is only written in the JSON format; see
+// sampleSummary for the other formats.
+func writeSample(w io.Writer, r *sampleResult, opts *Options) error {
+	scale := opts.scale()
+	div, p := math.Pow10(opts.valueScale()), math.Pow10(scale)
+	formatValue := func(v float64) json.Number {
+		return json.Number(strconv.FormatFloat(v, 'f', scale, 64))
+	}
+
+	records := sortRecords(r.infos(), opts)
+	estimates := make([]estimateJSON, len(records))
+	for i, rec := range records {
+		mean, margin, count := r.estimate(rec.name)
+		estimates[i] = estimateJSON{
+			Name:           rec.name,
+			Min:            formatValue(roundScale(float64(rec.info.Min)/div, scale)),
+			Mean:           formatValue(roundScale(mean/div, scale)),
+			Max:            formatValue(roundScale(float64(rec.info.Max)/div, scale)),
+			Count:          rec.info.Count,
+			EstimatedCount: int(math.Round(count)),
+		}
+		if !math.IsInf(margin, 1) {
+			estimates[i].Margin = formatValue(math.Ceil(margin/div*p) / p)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	switch opts.format() {
+	case formatJSON:
+		observed, estimate, low, high := r.stationCount()
+		b, err := json.Marshal(sampleJSON{
+			Segments:          r.segments,
+			Sampled:           r.sampled,
+			Confidence:        r.confidence,
+			ObservedStations:  observed,
+			EstimatedStations: math.Round(estimate),
+			StationsLow:       math.Floor(low),
+			StationsHigh:      math.Ceil(high),
+			Stations:          estimates,
+		})
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+		bw.WriteByte('\n')
+	case formatCSV:
+		cw := csv.NewWriter(bw)
+		cw.Write([]string{"station", "min", "mean", "max", "margin", "count", "estimated_count"})
+		for _, e := range estimates {
+			cw.Write([]string{e.Name, string(e.Min), string(e.Mean), string(e.Max), string(e.Margin), strconv.Itoa(e.Count), strconv.Itoa(e.EstimatedCount)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	default:
+		bw.WriteByte('{')
+		for i, e := range estimates {
+			if i > 0 {
+				bw.WriteString(", ")
+			}
+			if e.Margin == "" {
+				fmt.Fprintf(bw, "%s=%s/%s/%s", e.Name, e.Min, e.Mean, e.Max)
+				continue
+			}
+			fmt.Fprintf(bw, "%s=%s/%s±%s/%s", e.Name, e.Min, e.Mean, e.Margin, e.Max)
+		}
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+// sampleSummary returns a summary of the sample and the estimated number of
+// stations.
+func sampleSummary(r *sampleResult) string {
+	observed, estimate, low, high := r.stationCount()
+	return fmt.Sprintf("sampled %d of %d segments: %d stations seen, an estimated %.0f stations (%g%% confidence interval %.0f to %.0f)",
+		r.sampled, r.segments, observed, math.Round(estimate), r.confidence*100, math.Floor(low), math.Ceil(high))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeGeneratedFile writes rows with values for stations chosen uniformly at
+// random to a file in dir. Each station has a different mean.
+func writeGeneratedFile(t *testing.T, dir string, stations, rows int, seed int64) string {
+	t.Helper()
+
+	rng := rand.New(rand.NewSource(seed))
+	var b strings.Builder
+	for i := 0; i < rows; i++ {
+		s := rng.Intn(stations)
+		v := float64(s%50) - 10 + rng.NormFloat64()*10
+		fmt.Fprintf(&b, "station%d;%.1f\n", s, v)
+	}
+	path := filepath.Join(dir, "measurements.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func Test_processFileSample(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		stations, rows int
+		size           int
+		fraction       float64
+
+		// coverage is the minimum fraction of exact answers that
+		// must be within the estimated confidence intervals.
+		coverage float64
+	}{
+		"means": {
+			stations: 50,
+			rows:     100000,
+			size:     4096,
+			fraction: 0.1,
+			coverage: 0.9,
+		},
+		"stations": {
+			stations: 1000,
+			rows:     50000,
+			size:     1024,
+			fraction: 0.04,
+			coverage: 0.85,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeGeneratedFile(t, t.TempDir(), tc.stations, tc.rows, 1)
+			exact, err := processFileRandom(path, tc.size, &Options{})
+			if err != nil {
+				t.Fatalf("processFileRandom: %v", err)
+			}
+
+			// The estimates are random so check that the confidence
+			// intervals bracket the exact answer at close to the
+			// confidence level over a number of samples.
+			const seeds = 20
+			var means, bracketed, counts int
+			for seed := int64(1); seed <= seeds; seed++ {
+				r, err := processFileSample(path, tc.size, tc.fraction, defaultConfidence, seed, &Options{})
+				if err != nil {
+					t.Fatalf("processFileSample: %v", err)
+				}
+				if want := int(math.Ceil(tc.fraction * float64(r.segments))); r.sampled != want {
+					t.Fatalf("seed %d: want %d sampled segments, got %d", seed, want, r.sampled)
+				}
+
+				for name := range r.stations {
+					info := exact[name]
+					want := float64(info.Sum) / float64(info.Count)
+					mean, margin, _ := r.estimate(name)
+					if math.IsInf(margin, 1) {
+						// Too few segments with values to
+						// estimate the margin of error.
+						continue
+					}
+					means++
+					if want >= mean-margin && want <= mean+margin {
+						bracketed++
+					}
+				}
+
+				observed, estimate, low, high := r.stationCount()
+				if observed > len(exact) || estimate < float64(observed) || estimate < low || estimate > high {
+					t.Fatalf("seed %d: invalid station count: observed %d, estimated %v (%v to %v)", seed, observed, estimate, low, high)
+				}
+				if n := float64(len(exact)); n >= low && n <= high {
+					counts++
+				}
+			}
+
+			if got := float64(bracketed) / float64(means); means == 0 || got < tc.coverage {
+				t.Errorf("%d of %d means bracketed, want at least %v", bracketed, means, tc.coverage)
+			}
+			if got := float64(counts) / seeds; got < tc.coverage {
+				t.Errorf("%d of %d station counts bracketed, want at least %v", counts, seeds, tc.coverage)
+			}
+		})
+	}
+}
+
+func Test_processFileSample_all(t *testing.T) {
+	t.Parallel()
+
+	path := writeGeneratedFile(t, t.TempDir(), 20, 10000, 1)
+	exact, err := processFileRandom(path, 4096, &Options{})
+	if err != nil {
+		t.Fatalf("processFileRandom: %v", err)
+	}
+	r, err := processFileSample(path, 4096, 1, defaultConfidence, 1, &Options{})
+	if err != nil {
+		t.Fatalf("processFileSample: %v", err)
+	}
+
+	if diff := cmp.Diff(exact, r.infos()); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+	for name, info := range exact {
+		mean, margin, count := r.estimate(name)
+		if want := float64(info.Sum) / float64(info.Count); mean != want || margin != 0 || count != float64(info.Count) {
+			t.Fatalf("%s: want %v ± 0 and count %d, got %v ± %v and count %v", name, want, info.Count, mean, margin, count)
+		}
+	}
+	if observed, estimate, low, high := r.stationCount(); observed != 20 || estimate != 20 || low != 20 || high != 20 {
+		t.Fatalf("want 20 stations, got %d, %v (%v to %v)", observed, estimate, low, high)
+	}
+}
+
+func Test_writeSample(t *testing.T) {
+	t.Parallel()
+
+	r := &sampleResult{
+		segments:   4,
+		sampled:    2,
+		confidence: defaultConfidence,
+		stations: map[string]*clusterStats{
+			// Values 10.0 and 12.0 in one segment and 20.0 in the
+			// other.
+			"Halifax": {
+				info:     TempInfo{Min: 100, Max: 200, Sum: 420, Count: 3},
+				segments: 2,
+				xx:       4 + 1,
+				xy:       2*220 + 200,
+				yy:       220*220 + 200*200,
+			},
+			"Zagreb": {
+				info:     TempInfo{Min: 50, Max: 50, Sum: 50, Count: 1},
+				segments: 1,
+				xx:       1,
+				xy:       50,
+				yy:       50 * 50,
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected string
+	}{
+		"1brc": {
+			opts:     &Options{},
+			expected: "{Halifax=10.0/14.0±27.5/20.0, Zagreb=5.0/5.0/5.0}\n",
+		},
+		"csv": {
+			opts: &Options{Format: formatCSV, Sort: sortMean},
+			expected: "station,min,mean,max,margin,count,estimated_count\n" +
+				"Zagreb,5.0,5.0,5.0,,1,2\n" +
+				"Halifax,10.0,14.0,20.0,27.5,3,6\n",
+		},
+		"json": {
+			opts: &Options{Format: formatJSON, Top: 1},
+			expected: `{"segments":4,"sampled":2,"confidence":0.95,"observed_stations":2,` +
+				`"estimated_stations":2,"estimated_stations_low":2,"estimated_stations_high":2,` +
+				`"stations":[{"name":"Halifax","min":10.0,"mean":14.0,"max":20.0,"margin":27.5,"count":3,"estimated_count":6}]}` + "\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := writeSample(&b, r, tc.opts); err != nil {
+				t.Fatalf("writeSample: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, b.String()); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
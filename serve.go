@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server serves the results for a set of measurement files over HTTP.
+type server struct {
+	paths []string
+	opts  *Options
+
+	// follow is true if the files are followed as they grow.
+	follow bool
+
+	// loadMu serializes loading and polling.
+	loadMu    sync.Mutex
+	followers []*follower
+
+	mu       sync.RWMutex
+	stations map[string]*TempInfo
+}
+
+// newServer returns a new server for the files at paths.
+func newServer(paths []string, opts *Options, follow bool) *server {
+	return &server{
+		paths:    paths,
+		opts:     opts,
+		follow:   follow,
+		stations: make(map[string]*TempInfo),
+	}
+}
+
+// load processes the files from the start and replaces the results.
+func (s *server) load() error {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	if s.follow {
+		followers := make([]*follower, 0, len(s.paths))
+		for _, path := range s.paths {
+			fl, err := newFollower(path, s.opts)
+			if err != nil {
+				closeFollowers(followers)
+				return err
+			}
+			followers = append(followers, fl)
+		}
+		closeFollowers(s.followers)
+		s.followers = followers
+		return s.pollLocked()
+	}
+
+	m := make(map[string]*TempInfo, maxCities)
+	for _, path := range s.paths {
+		fm, err := processFileRandom(path, segmentSize, s.opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		mergeMap(m, fm)
+	}
+	if err := checkStationCount(m, s.opts); err != nil {
+		return err
+	}
+	s.publish(m)
+	return nil
+}
+
+// poll processes lines appended to followed files and updates the results.
+func (s *server) poll() error {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	return s.pollLocked()
+}
+
+func (s *server) pollLocked() error {
+	m := make(map[string]*TempInfo, maxCities)
+	for _, fl := range s.followers {
+		if err := fl.poll(); err != nil {
+			return err
+		}
+		// Copy the results as the follower updates them in place.
+		for k, v := range fl.m {
+			if prev, ok := m[k]; ok {
+				prev.merge(v)
+			} else {
+				m[k] = v.clone()
+			}
+		}
+	}
+	if err := checkStationCount(m, s.opts); err != nil {
+		return err
+	}
+	s.publish(m)
+	return nil
+}
+
+// publish replaces the results served with m.
+func (s *server) publish(m map[string]*TempInfo) {
+	s.mu.Lock()
+	s.stations = m
+	s.mu.Unlock()
+}
+
+// results returns the current results. The returned map must not be modified.
+func (s *server) results() map[string]*TempInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stations
+}
+
+// closeFollowers closes the files of the given followers.
+func closeFollowers(followers []*follower) {
+	for _, fl := range followers {
+		fl.Close()
+	}
+}
+
+// Close closes any followed files.
+func (s *server) Close() error {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	closeFollowers(s.followers)
+	s.followers = nil
+	return nil
+}
+
+// handler returns the HTTP handler for the server's API.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stations", s.handleStations)
+	mux.HandleFunc("/stations/", s.handleStation)
+	mux.HandleFunc("/result", s.handleResult)
+	mux.HandleFunc("/reload", s.handleReload)
+	return mux
+}
+
+// allowMethod returns true if the request uses the given method. Otherwise it
+// writes a 405 response.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+// windowStation identifies a station's result in a window.
+type windowStation struct {
+	Window string `json:"window"`
+	Name   string `json:"name"`
+}
+
+// handleStations serves the sorted list of station names. For windowed
+// results it serves the list of windows and station names sorted by window.
+func (s *server) handleStations(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	m := s.results()
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if s.opts.Window == 0 {
+		writeJSON(w, keys)
+		return
+	}
+	stations := make([]windowStation, len(keys))
+	for i, k := range keys {
+		window, name := splitWindowKey(k)
+		stations[i] = windowStation{Window: window, Name: name}
+	}
+	writeJSON(w, stations)
+}
+
+// handleStation serves the result for the station named in the path. For
+// windowed results the window query parameter selects the window by its start
+// time; without it the results for every window are served sorted by window.
+func (s *server) handleStation(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/stations/")
+	window := r.URL.Query().Get("window")
+	if window != "" {
+		if s.opts.Window == 0 {
+			http.Error(w, "results are not windowed", http.StatusBadRequest)
+			return
+		}
+		t, err := time.Parse(time.RFC3339, window)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid window %q", window), http.StatusBadRequest)
+			return
+		}
+		window = t.UTC().Format(windowLayout)
+	}
+
+	m := s.results()
+	newResult := func(window string, info *TempInfo) stationResult {
+		result := newStationResult(name, info, s.opts.valueScale(), s.opts.scale(), s.opts.unit(), s.opts.Columns)
+		result.Window = window
+		result.Metadata = stationMetadata(name, s.opts)
+		return result
+	}
+	if s.opts.Window == 0 || window != "" {
+		info, ok := m[window+name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("station %q not found", name), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, newResult(window, info))
+		return
+	}
+
+	var results []stationResult
+	for k, info := range m {
+		if kw, kn := splitWindowKey(k); kn == name {
+			results = append(results, newResult(kw, info))
+		}
+	}
+	if len(results) == 0 {
+		http.Error(w, fmt.Sprintf("station %q not found", name), http.StatusNotFound)
+		return
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Window < results[j].Window })
+	writeJSON(w, results)
+}
+
+// handleResult serves all results in the format given by the format query
+// parameter. The sort, desc and top query parameters select the order and
+// number of results.
+func (s *server) handleResult(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	opts := *s.opts
+	q := r.URL.Query()
+	if f := q.Get("format"); f != "" {
+		opts.Format = f
+	}
+	if o := q.Get("sort"); o != "" {
+		if !validSort(o) {
+			http.Error(w, fmt.Sprintf("unknown sort order %q", o), http.StatusBadRequest)
+			return
+		}
+		opts.Sort = o
+	}
+	if d := q.Get("desc"); d != "" {
+		desc, err := strconv.ParseBool(d)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid desc %q", d), http.StatusBadRequest)
+			return
+		}
+		opts.Desc = desc
+	}
+	if t := q.Get("top"); t != "" {
+		top, err := strconv.Atoi(t)
+		if err != nil || top < 0 {
+			http.Error(w, fmt.Sprintf("invalid top %q", t), http.StatusBadRequest)
+			return
+		}
+		opts.Top = top
+	}
+	var contentType string
+	switch opts.format() {
+	case format1BRC:
+		contentType = "text/plain; charset=utf-8"
+	case formatJSON:
+		contentType = "application/json"
+	case formatCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", opts.Format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if err := printMap(w, s.results(), &opts); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+// handleReload reloads the files from the start.
+func (s *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if err := s.load(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveCmd implements the serve command which serves the results for the given
+// files over HTTP until interrupted. The server is unauthenticated, so it
+// listens on localhost unless another address is given.
+func serveCmd(args []string, opts *Options) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:8080", "listen on `address`; the server is unauthenticated, so only use a public address such as :8080 on a trusted network")
+	follow := fs.Bool("follow", false, "follow the files as they grow")
+	interval := fs.Duration("interval", defaultInterval, "interval between polls in -follow mode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("serve: no input files")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("serve: invalid -interval: %v", *interval)
+	}
+
+	s := newServer(fs.Args(), opts, *follow)
+	defer s.Close()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *follow {
+		go func() {
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if err := s.poll(); err != nil {
+					log.Printf("polling: %v", err)
+				}
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving on %s", *listen)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_server(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte("Halifax;1.0\nZagreb;-3.1\nHalifax;3.0\nSt. John's, NL;2.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s := newServer([]string{path}, &Options{}, false)
+	defer s.Close()
+	if err := s.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	testCases := []struct {
+		name        string
+		method      string
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "stations",
+			method:      http.MethodGet,
+			path:        "/stations",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `["Halifax","St. John's, NL","Zagreb"]` + "\n",
+		},
+		{
+			name:        "station",
+			method:      http.MethodGet,
+			path:        "/stations/Halifax",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"name":"Halifax","min":1.0,"mean":2.0,"max":3.0,"count":2}` + "\n",
+		},
+		{
+			name:        "escaped station",
+			method:      http.MethodGet,
+			path:        "/stations/St.%20John's,%20NL",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"name":"St. John's, NL","min":2.0,"mean":2.0,"max":2.0,"count":1}` + "\n",
+		},
+		{
+			name:   "unknown station",
+			method: http.MethodGet,
+			path:   "/stations/Paris",
+			status: http.StatusNotFound,
+		},
+		{
+			name:        "result",
+			method:      http.MethodGet,
+			path:        "/result",
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        "{Halifax=1.0/2.0/3.0, St. John's, NL=2.0/2.0/2.0, Zagreb=-3.1/-3.1/-3.1}\n",
+		},
+		{
+			name:        "result csv",
+			method:      http.MethodGet,
+			path:        "/result?format=csv",
+			status:      http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body:        "station,min,mean,max,count\nHalifax,1.0,2.0,3.0,2\n\"St. John's, NL\",2.0,2.0,2.0,1\nZagreb,-3.1,-3.1,-3.1,1\n",
+		},
+		{
+			name:   "station window not windowed",
+			method: http.MethodGet,
+			path:   "/stations/Halifax?window=2026-10-17T12:00:00Z",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:        "result json",
+			method:      http.MethodGet,
+			path:        "/result?format=json",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body: `[{"name":"Halifax","min":1.0,"mean":2.0,"max":3.0,"count":2},` +
+				`{"name":"St. John's, NL","min":2.0,"mean":2.0,"max":2.0,"count":1},` +
+				`{"name":"Zagreb","min":-3.1,"mean":-3.1,"max":-3.1,"count":1}]` + "\n",
+		},
+		{
+			name:        "result sorted",
+			method:      http.MethodGet,
+			path:        "/result?sort=mean&desc=true&top=2",
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        "{Halifax=1.0/2.0/3.0, St. John's, NL=2.0/2.0/2.0}\n",
+		},
+		{
+			name:   "unknown sort",
+			method: http.MethodGet,
+			path:   "/result?sort=median",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid top",
+			method: http.MethodGet,
+			path:   "/result?top=-1",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown format",
+			method: http.MethodGet,
+			path:   "/result?format=xml",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "result post",
+			method: http.MethodPost,
+			path:   "/result",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "reload get",
+			method: http.MethodGet,
+			path:   "/reload",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		req, err := http.NewRequest(tc.method, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatalf("%s: NewRequest: %v", tc.name, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: Do: %v", tc.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: ReadAll: %v", tc.name, err)
+		}
+
+		if diff := cmp.Diff(tc.status, resp.StatusCode); diff != "" {
+			t.Errorf("%s: unexpected status (-want, +got):\n%s", tc.name, diff)
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if diff := cmp.Diff(tc.contentType, resp.Header.Get("Content-Type")); diff != "" {
+			t.Errorf("%s: unexpected content type (-want, +got):\n%s", tc.name, diff)
+		}
+		if diff := cmp.Diff(tc.body, string(body)); diff != "" {
+			t.Errorf("%s: unexpected body (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func Test_server_window(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	data := "Halifax;1.0;2026-10-17T12:05:00Z\n" +
+		"Zagreb;-3.1;2026-10-17T12:10:00Z\n" +
+		"Halifax;3.0;2026-10-17T13:15:00Z\n" +
+		"Halifax;5.0;2026-10-17T13:45:00Z\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s := newServer([]string{path}, &Options{Window: time.Hour}, false)
+	defer s.Close()
+	if err := s.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	testCases := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{
+			name:   "stations",
+			path:   "/stations",
+			status: http.StatusOK,
+			body: `[{"window":"2026-10-17T12:00:00Z","name":"Halifax"},` +
+				`{"window":"2026-10-17T12:00:00Z","name":"Zagreb"},` +
+				`{"window":"2026-10-17T13:00:00Z","name":"Halifax"}]` + "\n",
+		},
+		{
+			name:   "station",
+			path:   "/stations/Halifax",
+			status: http.StatusOK,
+			body: `[{"window":"2026-10-17T12:00:00Z","name":"Halifax","min":1.0,"mean":1.0,"max":1.0,"count":1},` +
+				`{"window":"2026-10-17T13:00:00Z","name":"Halifax","min":3.0,"mean":4.0,"max":5.0,"count":2}]` + "\n",
+		},
+		{
+			name:   "station window",
+			path:   "/stations/Halifax?window=2026-10-17T13:00:00Z",
+			status: http.StatusOK,
+			body:   `{"window":"2026-10-17T13:00:00Z","name":"Halifax","min":3.0,"mean":4.0,"max":5.0,"count":2}` + "\n",
+		},
+		{
+			name:   "station window offset",
+			path:   "/stations/Zagreb?window=2026-10-17T14:00:00%2B02:00",
+			status: http.StatusOK,
+			body:   `{"window":"2026-10-17T12:00:00Z","name":"Zagreb","min":-3.1,"mean":-3.1,"max":-3.1,"count":1}` + "\n",
+		},
+		{
+			name:   "station window not found",
+			path:   "/stations/Zagreb?window=2026-10-17T13:00:00Z",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "station not found",
+			path:   "/stations/Paris",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "invalid window",
+			path:   "/stations/Halifax?window=noon",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Get(ts.URL + tc.path)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", tc.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: ReadAll: %v", tc.name, err)
+		}
+
+		if diff := cmp.Diff(tc.status, resp.StatusCode); diff != "" {
+			t.Errorf("%s: unexpected status (-want, +got):\n%s", tc.name, diff)
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if diff := cmp.Diff(tc.body, string(body)); diff != "" {
+			t.Errorf("%s: unexpected body (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func Test_server_reload(t *testing.T) {
+	t.Parallel()
+
+	for _, follow := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "measurements.txt")
+		if err := os.WriteFile(path, []byte("Halifax;1.0\n"), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		s := newServer([]string{path}, &Options{}, follow)
+		defer s.Close()
+		if err := s.load(); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		ts := httptest.NewServer(s.handler())
+		defer ts.Close()
+
+		get := func() string {
+			resp, err := http.Get(ts.URL + "/result")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			return string(b)
+		}
+
+		if diff := cmp.Diff("{Halifax=1.0/1.0/1.0}\n", get()); diff != "" {
+			t.Fatalf("follow=%v: unexpected result (-want, +got):\n%s", follow, diff)
+		}
+
+		if err := os.WriteFile(path, []byte("Halifax;1.0\nHalifax;3.0\n"), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if follow {
+			// Polling picks up the appended line.
+			if err := s.poll(); err != nil {
+				t.Fatalf("poll: %v", err)
+			}
+			if diff := cmp.Diff("{Halifax=1.0/2.0/3.0}\n", get()); diff != "" {
+				t.Fatalf("follow=%v: unexpected result (-want, +got):\n%s", follow, diff)
+			}
+		}
+
+		resp, err := http.Post(ts.URL+"/reload", "", strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+		resp.Body.Close()
+		if diff := cmp.Diff(http.StatusNoContent, resp.StatusCode); diff != "" {
+			t.Fatalf("follow=%v: unexpected status (-want, +got):\n%s", follow, diff)
+		}
+		if diff := cmp.Diff("{Halifax=1.0/2.0/3.0}\n", get()); diff != "" {
+			t.Fatalf("follow=%v: unexpected result (-want, +got):\n%s", follow, diff)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"sort"
+)
+
+// Sort orders for results.
+const (
+	sortName  = "name"
+	sortMin   = "min"
+	sortMax   = "max"
+	sortMean  = "mean"
+	sortCount = "count"
+	sortRange = "range"
+)
+
+// validSort returns true if order is a known sort order.
+func validSort(order string) bool {
+	switch order {
+	case sortName, sortMin, sortMax, sortMean, sortCount, sortRange:
+		return true
+	}
+	return false
+}
+
+// record is the result for a single station. For windowed results name is a
+// windowed key.
+type record struct {
+	name string
+	info *TempInfo
+}
+
+// recordLess returns a function that reports whether record a sorts before
+// record b in the order given by opts. Stations with equal values are ordered
+// by name. Names are compared in the collation given by opts.Collate.
+func recordLess(opts *Options) func(a, b record) bool {
+	var value func(info *TempInfo) float64
+	switch opts.sort() {
+	case sortMin:
+		value = func(info *TempInfo) float64 { return float64(info.Min) }
+	case sortMax:
+		value = func(info *TempInfo) float64 { return float64(info.Max) }
+	case sortMean:
+		value = func(info *TempInfo) float64 { return float64(info.Sum) / float64(info.Count) }
+	case sortCount:
+		value = func(info *TempInfo) float64 { return float64(info.Count) }
+	case sortRange:
+		value = func(info *TempInfo) float64 { return float64(info.Max) - float64(info.Min) }
+	}
+
+	desc := opts.Desc
+	compareName := nameCompare(opts)
+	return func(a, b record) bool {
+		if value != nil {
+			if c := cmp.Compare(value(a.info), value(b.info)); c != 0 {
+				return (c < 0) != desc
+			}
+			return compareName(a.name, b.name) < 0
+		}
+		if desc {
+			return compareName(a.name, b.name) > 0
+		}
+		return compareName(a.name, b.name) < 0
+	}
+}
+
+// recordSorter collects records and keeps them in the order given by
+// opts, keeping only the first opts.Top records if it is set.
+type recordSorter struct {
+	less    func(a, b record) bool
+	top     int
+	records []record
+}
+
+// newRecordSorter returns a new recordSorter for the given options.
+func newRecordSorter(opts *Options) *recordSorter {
+	return &recordSorter{
+		less: recordLess(opts),
+		top:  opts.Top,
+	}
+}
+
+// add adds a record. Records that cannot be in the first top records are
+// discarded periodically so that at most 2*top records are held.
+func (s *recordSorter) add(name string, info *TempInfo) {
+	s.records = append(s.records, record{name: name, info: info})
+	if s.top > 0 && len(s.records) >= 2*s.top {
+		s.truncate()
+	}
+}
+
+// truncate sorts the records and keeps the first top records.
+func (s *recordSorter) truncate() {
+	sort.Slice(s.records, func(i, j int) bool {
+		return s.less(s.records[i], s.records[j])
+	})
+	if s.top > 0 && len(s.records) > s.top {
+		clear(s.records[s.top:])
+		s.records = s.records[:s.top]
+	}
+}
+
+// sorted returns the sorted records.
+func (s *recordSorter) sorted() []record {
+	s.truncate()
+	return s.records
+}
+
+// sortRecords returns the results in m in the order given by opts, keeping
+// only the first opts.Top records if it is set.
+func sortRecords(m map[string]*TempInfo, opts *Options) []record {
+	s := newRecordSorter(opts)
+	s.records = make([]record, 0, len(m))
+	for name, info := range m {
+		s.add(name, info)
+	}
+	return s.sorted()
+}
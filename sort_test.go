@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_sortRecords(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]*TempInfo{
+		"Adelaide": {Min: 150, Max: 150, Sum: 150, Count: 1},
+		"Halifax":  {Min: -31, Max: 129, Sum: 98, Count: 2},
+		"Karachi":  {Min: 154, Max: 154, Sum: 154, Count: 1},
+		"Tauranga": {Min: 20, Max: 382, Sum: 802, Count: 4},
+		"Zagreb":   {Min: -5, Max: 122, Sum: 131, Count: 3},
+	}
+
+	testCases := map[string]struct {
+		opts     *Options
+		expected []string
+	}{
+		"default": {
+			opts:     &Options{},
+			expected: []string{"Adelaide", "Halifax", "Karachi", "Tauranga", "Zagreb"},
+		},
+		"name desc": {
+			opts:     &Options{Sort: sortName, Desc: true},
+			expected: []string{"Zagreb", "Tauranga", "Karachi", "Halifax", "Adelaide"},
+		},
+		"min": {
+			opts:     &Options{Sort: sortMin},
+			expected: []string{"Halifax", "Zagreb", "Tauranga", "Adelaide", "Karachi"},
+		},
+		"max desc": {
+			opts:     &Options{Sort: sortMax, Desc: true},
+			expected: []string{"Tauranga", "Karachi", "Adelaide", "Halifax", "Zagreb"},
+		},
+		"mean": {
+			opts:     &Options{Sort: sortMean},
+			expected: []string{"Zagreb", "Halifax", "Adelaide", "Karachi", "Tauranga"},
+		},
+		"count ties by name": {
+			opts:     &Options{Sort: sortCount},
+			expected: []string{"Adelaide", "Karachi", "Halifax", "Zagreb", "Tauranga"},
+		},
+		"count desc ties by name": {
+			opts:     &Options{Sort: sortCount, Desc: true},
+			expected: []string{"Tauranga", "Zagreb", "Halifax", "Adelaide", "Karachi"},
+		},
+		"range": {
+			opts:     &Options{Sort: sortRange},
+			expected: []string{"Adelaide", "Karachi", "Zagreb", "Halifax", "Tauranga"},
+		},
+		"top": {
+			opts:     &Options{Sort: sortMax, Desc: true, Top: 2},
+			expected: []string{"Tauranga", "Karachi"},
+		},
+		"top larger than results": {
+			opts:     &Options{Top: 10},
+			expected: []string{"Adelaide", "Halifax", "Karachi", "Tauranga", "Zagreb"},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			for _, r := range sortRecords(m, tc.opts) {
+				got = append(got, r.name)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
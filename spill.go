@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+)
+
+const (
+	// entryOverhead is the approximate number of bytes used by a map entry in
+	// addition to the bytes of its key.
+	entryOverhead = 128
+
+	// maxMergeRuns is the maximum number of runs merged at once.
+	maxMergeRuns = 64
+
+	// minSpillChunkSize is the minimum chunk size used when spilling.
+	minSpillChunkSize = 64 * 1024 // 64kb
+
+	// maxRecordName is the maximum length of a station name in a record.
+	maxRecordName = 16 * 1024 * 1024 // 16mb
+
+	// maxRecordColumns is the maximum number of extra value columns in a
+	// record.
+	maxRecordColumns = 1024
+)
+
+// spillMerger merges maps into an in-memory map. When the estimated size of
+// the map exceeds the memory budget it is written to disk as a run of records
+// sorted by name and a new map is started. Runs are merged when the results
+// are read.
+type spillMerger struct {
+	budget int64
+	dir    string
+
+	m    map[string]*TempInfo
+	size int64
+
+	runs []string
+
+	// maxRuns is the maximum number of runs merged at once.
+	maxRuns int
+}
+
+// newSpillMerger returns a new spillMerger that spills to disk when its map
+// grows beyond approximately budget bytes.
+func newSpillMerger(budget int64) *spillMerger {
+	return &spillMerger{
+		budget:  budget,
+		m:       make(map[string]*TempInfo, maxCities),
+		maxRuns: maxMergeRuns,
+	}
+}
+
+// spillChunkSize returns the chunk size to use with the given memory budget.
+// Half of the budget is reserved for results and a quarter for chunks and
+// per-chunk maps in flight. There are up to about 4 chunks and 3 maps per CPU
+// in flight and a map can use up to about 8 times the memory of its chunk.
+func spillChunkSize(budget int64) int {
+	size := budget / 4 / int64(runtime.NumCPU()*28)
+	if size < minSpillChunkSize {
+		return minSpillChunkSize
+	}
+	if size > chunkSize {
+		return chunkSize
+	}
+	return int(size)
+}
+
+// keyCacheBudget returns the number of bytes each parser's key cache may use
+// with the given memory budget. The remaining quarter of the budget is shared
+// by the key caches of the parsers, one per CPU and one for the reader.
+func keyCacheBudget(budget int64) int64 {
+	return budget / 4 / int64(runtime.NumCPU()+1)
+}
+
+// processFileSpill reads the file and produces the results for the entire file
+// using at most approximately opts.MemoryBudget bytes of memory for results.
+// The returned spillMerger must be closed to remove temporary files.
+func processFileSpill(r io.Reader, opts *Options) (*spillMerger, error) {
+	sm := newSpillMerger(opts.MemoryBudget / 2)
+	if err := aggregateFile(r, spillChunkSize(opts.MemoryBudget), opts, sm.merge); err != nil {
+		sm.Close()
+		return nil, err
+	}
+	return sm, nil
+}
+
+// merge merges m into the results.
+func (s *spillMerger) merge(m map[string]*TempInfo) error {
+	for k, v := range m {
+		if info, ok := s.m[k]; ok {
+			info.merge(v)
+		} else {
+			s.m[k] = v
+			s.size += int64(len(k)) + entryOverhead
+		}
+	}
+	if s.size > s.budget {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill writes the in-memory map to a new run.
+func (s *spillMerger) spill() error {
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	err := s.writeRun(func(fn func(string, *TempInfo) error) error {
+		for _, k := range keys {
+			if err := fn(k, s.m[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.m = make(map[string]*TempInfo, maxCities)
+	s.size = 0
+
+	if len(s.runs) >= 2*s.maxRuns {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact merges the oldest runs into a single run so that the number of
+// runs merged at once stays below maxRuns.
+func (s *spillMerger) compact() error {
+	runs := s.runs[:s.maxRuns]
+	rest := s.runs[s.maxRuns:]
+	s.runs = rest
+
+	err := s.writeRun(func(fn func(string, *TempInfo) error) error {
+		return mergeRuns(runs, fn)
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range runs {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// writeRun writes the sorted records produced by each to a new run.
+func (s *spillMerger) writeRun(each func(func(string, *TempInfo) error) error) error {
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "1brc-go-spill-")
+		if err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+		s.dir = dir
+	}
+
+	f, err := os.CreateTemp(s.dir, "run-")
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	var buf []byte
+	err = each(func(name string, info *TempInfo) error {
+		buf = appendRecord(buf[:0], name, info)
+		_, err := w.Write(buf)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+
+	s.runs = append(s.runs, f.Name())
+	return nil
+}
+
+// each calls fn for the result of each station in name order.
+func (s *spillMerger) each(fn func(name string, info *TempInfo) error) error {
+	if len(s.runs) == 0 {
+		keys := make([]string, 0, len(s.m))
+		for k := range s.m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := fn(k, s.m[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.m) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	for len(s.runs) > s.maxRuns {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	return mergeRuns(s.runs, fn)
+}
+
+// writeTo writes the results to w in the format expected for the 1 billion
+// row challenge.
+func (s *spillMerger) writeTo(w io.Writer, opts *Options) error {
+	rw := newResultWriter(w, opts)
+	if opts.nameOrder() {
+		// Results are merged in name order so they can be written as
+		// they are merged.
+		err := s.each(func(name string, info *TempInfo) error {
+			if opts.Top == 0 || rw.n < opts.Top {
+				rw.write(name, info)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return rw.close()
+	}
+
+	rs := newRecordSorter(opts)
+	if err := s.each(func(name string, info *TempInfo) error {
+		rs.add(name, info)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, r := range rs.sorted() {
+		rw.write(r.name, r.info)
+	}
+	return rw.close()
+}
+
+// Close removes any temporary files.
+func (s *spillMerger) Close() error {
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// appendRecord appends the binary encoding of a station's results to b.
+func appendRecord(b []byte, name string, info *TempInfo) []byte {
+	b = appendBaseRecord(b, name, info)
+	b = binary.AppendUvarint(b, uint64(len(info.Extra)))
+	for i := range info.Extra {
+		b = appendStats(b, &info.Extra[i])
+	}
+	return b
+}
+
+// appendBaseRecord appends the binary encoding of a station's name and the
+// results for its first value column to b. This is the record format of
+// version 1 and 2 partial results.
+func appendBaseRecord(b []byte, name string, info *TempInfo) []byte {
+	b = binary.AppendUvarint(b, uint64(len(name)))
+	b = append(b, name...)
+	return appendStats(b, info)
+}
+
+// appendStats appends the binary encoding of the stats for a value column to
+// b.
+func appendStats(b []byte, info *TempInfo) []byte {
+	b = binary.AppendVarint(b, int64(info.Min))
+	b = binary.AppendVarint(b, int64(info.Max))
+	b = binary.AppendVarint(b, int64(info.Sum))
+	b = binary.AppendUvarint(b, uint64(info.Count))
+	return b
+}
+
+// readRecord reads a record written by appendRecord, or by appendBaseRecord if
+// extra is false. It returns io.EOF if there are no more records.
+func readRecord(r *bufio.Reader, extra bool) (string, *TempInfo, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > maxRecordName {
+		return "", nil, fmt.Errorf("%w: record name too long", errInputFormat)
+	}
+	name := make([]byte, n)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", nil, noEOF(err)
+	}
+
+	info := &TempInfo{}
+	if err := readStats(r, info); err != nil {
+		return "", nil, err
+	}
+	if !extra {
+		return string(name), info, nil
+	}
+
+	n, err = binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, noEOF(err)
+	}
+	if n > maxRecordColumns {
+		return "", nil, fmt.Errorf("%w: too many columns in record", errInputFormat)
+	}
+	if n > 0 {
+		info.Extra = make([]TempInfo, n)
+		for i := range info.Extra {
+			if err := readStats(r, &info.Extra[i]); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	return string(name), info, nil
+}
+
+// readStats reads the stats for a value column written by appendStats into
+// info.
+func readStats(r *bufio.Reader, info *TempInfo) error {
+	var vals [3]int64
+	var err error
+	for i := range vals {
+		if vals[i], err = binary.ReadVarint(r); err != nil {
+			return noEOF(err)
+		}
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return noEOF(err)
+	}
+	info.Min = int(vals[0])
+	info.Max = int(vals[1])
+	info.Sum = int(vals[2])
+	info.Count = int(count)
+	return nil
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// runReader reads records from a run.
+type runReader struct {
+	f    *os.File
+	r    *bufio.Reader
+	name string
+	info *TempInfo
+}
+
+// next reads the next record. It returns io.EOF at the end of the run.
+func (rr *runReader) next() error {
+	var err error
+	rr.name, rr.info, err = readRecord(rr.r, true)
+	return err
+}
+
+// runHeap is a min-heap of runReaders ordered by the name of their current
+// record.
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].name < h[j].name }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// mergeRuns merges the sorted runs at the given paths and calls fn for each
+// station in name order.
+func mergeRuns(paths []string, fn func(name string, info *TempInfo) error) error {
+	h := make(runHeap, 0, len(paths))
+	defer func() {
+		for _, rr := range h {
+			rr.f.Close()
+		}
+	}()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+		rr := &runReader{f: f, r: bufio.NewReader(f)}
+		if err := rr.next(); err != nil {
+			f.Close()
+			if errors.Is(err, io.EOF) {
+				continue
+			}
+			return fmt.Errorf("spill: reading %q: %w", path, err)
+		}
+		h = append(h, rr)
+	}
+	heap.Init(&h)
+
+	// advance moves the reader at the top of the heap to its next record.
+	advance := func() error {
+		rr := h[0]
+		err := rr.next()
+		if errors.Is(err, io.EOF) {
+			rr.f.Close()
+			heap.Pop(&h)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("spill: reading %q: %w", rr.f.Name(), err)
+		}
+		heap.Fix(&h, 0)
+		return nil
+	}
+
+	for len(h) > 0 {
+		name, info := h[0].name, h[0].info
+		if err := advance(); err != nil {
+			return err
+		}
+		for len(h) > 0 && h[0].name == name {
+			info.merge(h[0].info)
+			if err := advance(); err != nil {
+				return err
+			}
+		}
+		if err := fn(name, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
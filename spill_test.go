@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_appendRecord(t *testing.T) {
+	t.Parallel()
+
+	infos := map[string]*TempInfo{
+		"":        {},
+		"Halifax": {Min: -999, Max: 999, Sum: -12345, Count: 7},
+		"Zagreb":  {Min: 10, Max: 20, Sum: 1 << 40, Count: 1 << 33},
+		"Lisbon": {
+			Min: -5, Max: 5, Sum: 0, Count: 2,
+			Extra: []TempInfo{{Min: 400, Max: 700, Sum: 1100, Count: 2}, {}},
+		},
+	}
+
+	var b []byte
+	for _, name := range []string{"", "Halifax", "Zagreb", "Lisbon"} {
+		b = appendRecord(b, name, infos[name])
+	}
+
+	r := bufio.NewReader(bytes.NewReader(b))
+	got := map[string]*TempInfo{}
+	for {
+		name, info, err := readRecord(r, true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("readRecord: %v", err)
+		}
+		got[name] = info
+	}
+	if diff := cmp.Diff(infos, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	// A truncated record is an error.
+	var err error
+	r = bufio.NewReader(bytes.NewReader(b[:len(b)-1]))
+	for err == nil {
+		_, _, err = readRecord(r, true)
+	}
+	if diff := cmp.Diff(io.ErrUnexpectedEOF, err, cmpopts.EquateErrors()); diff != "" {
+		t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+	}
+}
+
+func Test_spillMerger(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		budget  int64
+		maxRuns int
+		runs    bool
+	}{
+		"in memory": {
+			budget:  1 << 30,
+			maxRuns: maxMergeRuns,
+		},
+		"spill": {
+			budget:  4096,
+			maxRuns: maxMergeRuns,
+			runs:    true,
+		},
+		"compact": {
+			budget:  512,
+			maxRuns: 2,
+			runs:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			sm := newSpillMerger(tc.budget)
+			sm.maxRuns = tc.maxRuns
+			defer sm.Close()
+
+			expected := map[string]*TempInfo{}
+			for i := 0; i < 50; i++ {
+				m := map[string]*TempInfo{}
+				for j := 0; j < 20; j++ {
+					n := (i*7 + j*13) % 101
+					k := fmt.Sprintf("station%03d", (i*31+j*17)%300)
+					if info, ok := m[k]; ok {
+						info.merge(&TempInfo{Min: n, Max: n, Sum: n, Count: 1})
+					} else {
+						m[k] = &TempInfo{Min: n, Max: n, Sum: n, Count: 1}
+					}
+				}
+				for k, v := range m {
+					c := *v
+					if info, ok := expected[k]; ok {
+						info.merge(&c)
+					} else {
+						expected[k] = &c
+					}
+				}
+				if err := sm.merge(m); err != nil {
+					t.Fatalf("merge: %v", err)
+				}
+			}
+
+			got := map[string]*TempInfo{}
+			var prev string
+			err := sm.each(func(name string, info *TempInfo) error {
+				if name <= prev && prev != "" {
+					t.Errorf("unexpected order: %q after %q", name, prev)
+				}
+				prev = name
+				got[name] = info
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("each: %v", err)
+			}
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+			if got := len(sm.runs) > 0; got != tc.runs {
+				t.Fatalf("unexpected runs: %v", sm.runs)
+			}
+			if len(sm.runs) > tc.maxRuns {
+				t.Fatalf("too many runs: %d", len(sm.runs))
+			}
+		})
+	}
+}
+
+func Test_processFileSpill(t *testing.T) {
+	t.Parallel()
+
+	// Generate a file with more unique stations than maxCities.
+	var b strings.Builder
+	for i := 0; i < 3*maxCities; i++ {
+		fmt.Fprintf(&b, "id%d;%d.%d\n", i%(2*maxCities), i%100-50, i%10)
+	}
+	input := b.String()
+
+	m, err := processFile(strings.NewReader(input), chunkSize, nil)
+	if err != nil {
+		t.Fatalf("processFile: %v", err)
+	}
+	var want bytes.Buffer
+	if err := printMap(&want, m, &Options{}); err != nil {
+		t.Fatalf("printMap: %v", err)
+	}
+
+	sm, err := processFileSpill(strings.NewReader(input), &Options{MemoryBudget: 256 * 1024})
+	if err != nil {
+		t.Fatalf("processFileSpill: %v", err)
+	}
+	defer sm.Close()
+	if len(sm.runs) == 0 {
+		t.Fatalf("expected spilled runs")
+	}
+
+	var got bytes.Buffer
+	if err := sm.writeTo(&got, &Options{}); err != nil {
+		t.Fatalf("writeTo: %v", err)
+	}
+	if diff := cmp.Diff(want.String(), got.String()); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+
+	// Sorted views of the results match those of the in-memory results.
+	for _, opts := range []*Options{
+		{Top: 3},
+		{Desc: true, Top: 5},
+		{Sort: sortMax, Desc: true, Top: 20},
+		{Sort: sortCount, Top: 7},
+	} {
+		want.Reset()
+		if err := printMap(&want, m, opts); err != nil {
+			t.Fatalf("printMap(%+v): %v", opts, err)
+		}
+		got.Reset()
+		if err := sm.writeTo(&got, opts); err != nil {
+			t.Fatalf("writeTo(%+v): %v", opts, err)
+		}
+		if diff := cmp.Diff(want.String(), got.String()); diff != "" {
+			t.Fatalf("%+v: unexpected result (-want, +got):\n%s", opts, diff)
+		}
+	}
+
+	dir := sm.dir
+	if err := sm.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected %q to be removed: %v", dir, err)
+	}
+}
+
+func Test_parseSize(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s    string
+		size int64
+		err  bool
+	}{
+		"empty": {
+			s: "",
+		},
+		"bytes": {
+			s:    "1024",
+			size: 1024,
+		},
+		"kilobytes": {
+			s:    "4K",
+			size: 4096,
+		},
+		"megabytes": {
+			s:    "512m",
+			size: 512 << 20,
+		},
+		"gigabytes": {
+			s:    "2G",
+			size: 2 << 30,
+		},
+		"negative": {
+			s:   "-1",
+			err: true,
+		},
+		"invalid": {
+			s:   "1T",
+			err: true,
+		},
+		"overflow": {
+			s:   "9223372036854775807G",
+			err: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			size, err := parseSize(tc.s)
+			if got := err != nil; got != tc.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.size, size); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parser_keyCacheBudget(t *testing.T) {
+	t.Parallel()
+
+	b, err := os.ReadFile("test/measurements-10000-unique-keys.txt")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	want, err := newParser(&Options{Prefix: "id"}).processChunk(b, 0)
+	if err != nil {
+		t.Fatalf("processChunk: %v", err)
+	}
+
+	// The key cache is cleared to stay within its share of the budget.
+	p := newParser(&Options{Prefix: "id", MemoryBudget: 1 << 20})
+	got, err := p.processChunk(b, 0)
+	if err != nil {
+		t.Fatalf("processChunk: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+	}
+	if p.keyCacheSize > p.keyCacheBudget {
+		t.Fatalf("key cache size %d exceeds budget %d", p.keyCacheSize, p.keyCacheBudget)
+	}
+	if n := int64(len(p.keyCache)) * entryOverhead; n > p.keyCacheBudget {
+		t.Fatalf("key cache has %d entries with budget %d", len(p.keyCache), p.keyCacheBudget)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+const (
+	// stateMagic identifies the state file format.
+	stateMagic = "1BRCSTAT"
+
+	// stateVersion is the current version of the state file format.
+	stateVersion = 2
+
+	// headHashSize is the number of bytes at the start of the file hashed to
+	// detect files that were rewritten in place.
+	headHashSize = 4096
+)
+
+var errStateFormat = errors.New("bad state file format")
+
+// fileState is the state persisted between incremental runs over a file.
+type fileState struct {
+	// Offset is the byte offset after the last fully processed line.
+	Offset int64
+
+	// Size is the size of the file when it was last processed.
+	Size int64
+
+	// Inode identifies the file so that rotation can be detected.
+	Inode uint64
+
+	// HeadHash is the CRC-32C of the first min(Offset, headHashSize) bytes
+	// of the file.
+	HeadHash uint32
+
+	// OptionsHash is the fingerprint of the options that affect the
+	// result. See optionsHash.
+	OptionsHash uint64
+
+	// Result is the result for the file up to Offset.
+	Result *partial
+}
+
+// headHash returns the hash of the head of data, which ends at the last fully
+// processed line.
+func headHash(data []byte) uint32 {
+	if len(data) > headHashSize {
+		data = data[:headHashSize]
+	}
+	return crc32.Checksum(data, crcTable)
+}
+
+// optionsHash returns a fingerprint of the options that affect how lines are
+// parsed and aggregated, so that a state is not used with results produced
+// differently. Options that only affect how results are written are not
+// included.
+func optionsHash(opts *Options) uint64 {
+	var b []byte
+	str := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	strs := func(s []string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		for _, v := range s {
+			str(v)
+		}
+	}
+	strMap := func(m map[string]string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = binary.AppendUvarint(b, uint64(len(keys)))
+		for _, k := range keys {
+			str(k)
+			str(m[k])
+		}
+	}
+	flag := func(v bool) {
+		if v {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+
+	flag(opts.CRLF)
+	flag(opts.SkipBOM)
+	str(opts.CommentPrefix)
+	flag(opts.SkipBlank)
+	b = append(b, opts.delim(), opts.decimal())
+	flag(opts.Header)
+	b = binary.AppendUvarint(b, uint64(opts.valueScale()))
+	flag(opts.Strict)
+	flag(opts.UnitSuffix)
+	strs(opts.Columns)
+	flag(opts.timestamps())
+	b = binary.AppendVarint(b, int64(opts.Window))
+
+	// Only the set of selected stations matters, not their order.
+	if opts.Stations == nil {
+		flag(false)
+	} else {
+		flag(true)
+		stations := slices.Clone(opts.Stations)
+		sort.Strings(stations)
+		strs(stations)
+	}
+	str(opts.Prefix)
+	str(opts.Match)
+	strMap(opts.Aliases)
+	strMap(opts.Groups)
+	str(opts.GroupMatch)
+	str(opts.GroupBy)
+	strMap(opts.Where)
+	str(opts.Normalize)
+	flag(opts.FoldCase)
+
+	// Metadata only affects the result through GroupBy and Where.
+	if md := opts.Metadata; md != nil && (opts.GroupBy != "" || opts.Where != nil) {
+		strs(md.Columns)
+		names := make([]string, 0, len(md.Stations))
+		for name := range md.Stations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			str(name)
+			strs(md.Stations[name])
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// check returns an error describing why the state cannot be used to
+// incrementally process data, or nil if the state is valid for data.
+func (s *fileState) check(data []byte, inode uint64, opts *Options) error {
+	switch {
+	case inode != s.Inode:
+		return errors.New("file was replaced")
+	case int64(len(data)) < s.Size || int64(len(data)) < s.Offset:
+		return errors.New("file was truncated")
+	case headHash(data[:s.Offset]) != s.HeadHash:
+		return errors.New("file was rewritten")
+	case opts.valueScale() != s.Result.Scale:
+		return fmt.Errorf("scale changed from %d to %d", s.Result.Scale, opts.valueScale())
+	case opts.Window != s.Result.Window:
+		return fmt.Errorf("window changed from %v to %v", s.Result.Window, opts.Window)
+	case !slices.Equal(opts.Columns, s.Result.Columns):
+		return fmt.Errorf("columns changed from %q to %q", s.Result.Columns, opts.Columns)
+	case optionsHash(opts) != s.OptionsHash:
+		return errors.New("options changed")
+	}
+	return nil
+}
+
+// writeState writes s to w.
+//
+// The format is the magic string "1BRCSTAT", a big-endian uint16 version, the
+// offset, size and inode as uvarints, the big-endian head hash, the big-endian
+// options hash, the length of
+// the result as a uvarint, the result in the binary partial result format, and
+// a big-endian CRC-32C of all preceding bytes.
+func writeState(w io.Writer, s *fileState) error {
+	var p bytes.Buffer
+	if err := writePartial(&p, s.Result, formatBinary); err != nil {
+		return err
+	}
+
+	b := []byte(stateMagic)
+	b = binary.BigEndian.AppendUint16(b, stateVersion)
+	b = binary.AppendUvarint(b, uint64(s.Offset))
+	b = binary.AppendUvarint(b, uint64(s.Size))
+	b = binary.AppendUvarint(b, s.Inode)
+	b = binary.BigEndian.AppendUint32(b, s.HeadHash)
+	b = binary.BigEndian.AppendUint64(b, s.OptionsHash)
+	b = binary.AppendUvarint(b, uint64(p.Len()))
+	b = append(b, p.Bytes()...)
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, crcTable))
+	_, err := w.Write(b)
+	return err
+}
+
+// readState reads a state written by writeState.
+func readState(r io.Reader) (*fileState, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	const headerLen = len(stateMagic) + 2
+	if len(b) < headerLen+4 || string(b[:len(stateMagic)]) != stateMagic {
+		return nil, fmt.Errorf("%w: missing header", errStateFormat)
+	}
+	if v := binary.BigEndian.Uint16(b[len(stateMagic):]); v != stateVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errStateFormat, v)
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.Checksum(body, crcTable) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", errStateFormat)
+	}
+
+	br := bytes.NewReader(body[headerLen:])
+	var vals [3]uint64
+	for i := range vals {
+		if vals[i], err = binary.ReadUvarint(br); err != nil {
+			return nil, fmt.Errorf("%w: %w", errStateFormat, noEOF(err))
+		}
+	}
+	var hash [12]byte
+	if _, err := io.ReadFull(br, hash[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", errStateFormat, noEOF(err))
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errStateFormat, noEOF(err))
+	}
+	if n != uint64(br.Len()) {
+		return nil, fmt.Errorf("%w: bad result length", errStateFormat)
+	}
+	p, err := readPartial(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errStateFormat, err)
+	}
+
+	s := &fileState{
+		Offset:      int64(vals[0]),
+		Size:        int64(vals[1]),
+		Inode:       vals[2],
+		HeadHash:    binary.BigEndian.Uint32(hash[:4]),
+		OptionsHash: binary.BigEndian.Uint64(hash[4:]),
+		Result:      p,
+	}
+	if s.Offset < 0 || s.Size < s.Offset {
+		return nil, fmt.Errorf("%w: bad offset", errStateFormat)
+	}
+	return s, nil
+}
+
+// readStateFile reads the state from the file at path. It returns nil if the
+// file does not exist.
+func readStateFile(path string) (*fileState, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := readState(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// writeStateFile atomically replaces the file at path with s.
+func writeStateFile(path string, s *fileState) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := writeState(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// processFileIncremental produces the result for the file at path using the
+// state stored at statePath. Only lines appended since the state was written
+// are processed. If the file was truncated, rotated or rewritten all lines
+// are processed. A trailing line without a newline is not processed until it
+// is complete. The updated state is written to statePath.
+func processFileIncremental(path, statePath string, opts *Options, logf func(format string, args ...any)) (*partial, error) {
+	s, err := readStateFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	inode := fileInode(fi)
+
+	var start int64
+	result := newPartial(opts, nil)
+	if s != nil {
+		if err := s.check(data, inode, opts); err != nil {
+			logf("%s: processing entire file: %v", path, err)
+		} else {
+			start = s.Offset
+			result = s.Result
+		}
+	}
+
+	end := int64(bytes.LastIndexByte(data, '\n') + 1)
+	m, err := processDataRandom(data[start:end], start, segmentSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	mergeMap(result.Stations, m)
+	if err := checkStationCount(result.Stations, opts); err != nil {
+		return nil, err
+	}
+
+	err = writeStateFile(statePath, &fileState{
+		Offset:      end,
+		Size:        int64(len(data)),
+		Inode:       inode,
+		HeadHash:    headHash(data[:end]),
+		OptionsHash: optionsHash(opts),
+		Result:      result,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("writing state: %w", err)
+	}
+	return result, nil
+}
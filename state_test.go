@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_processFileIncremental(t *testing.T) {
+	t.Parallel()
+
+	// step changes the input file before a run.
+	type step struct {
+		// write replaces the file contents.
+		write string
+
+		// appendData is appended to the file.
+		appendData string
+
+		// replace replaces the file with a new file with the given
+		// contents.
+		replace string
+
+		opts *Options
+
+		// full is true if the entire file should be processed.
+		full bool
+	}
+
+	testCases := map[string]struct {
+		steps []step
+	}{
+		"append": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{appendData: "foo;3.0\nbaz;-1.0\n"},
+				{appendData: ""},
+				{appendData: "bar;4.0\n"},
+			},
+		},
+		"incomplete line": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.", full: true},
+				{appendData: "5\nbaz;"},
+				{appendData: "1.0\n"},
+			},
+		},
+		"truncated": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{write: "foo;5.0\n", full: true},
+				{appendData: "bar;1.0\n"},
+			},
+		},
+		"rewritten": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{write: "foo;5.0\nbar;2.0\nbaz;3.0\n", full: true},
+			},
+		},
+		"rotated": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{replace: "foo;1.0\nbar;2.0\nbaz;3.0\n", full: true},
+				{appendData: "bar;1.0\n"},
+			},
+		},
+		"scale changed": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{appendData: "bar;1.25\n", opts: &Options{Scale: 2}, full: true},
+			},
+		},
+		"prefix changed": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{appendData: "foo;3.0\n", opts: &Options{Prefix: "f"}, full: true},
+				{appendData: "bar;1.0\n", opts: &Options{Prefix: "f"}},
+			},
+		},
+		"aliases changed": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", opts: &Options{Aliases: map[string]string{"bar": "foo"}}, full: true},
+				{appendData: "bar;1.0\n", opts: &Options{Aliases: map[string]string{"bar": "baz"}}, full: true},
+			},
+		},
+		"unit suffix changed": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\n", full: true},
+				{appendData: "bar;1.0\n", opts: &Options{UnitSuffix: true}, full: true},
+			},
+		},
+		"stations reordered": {
+			steps: []step{
+				{write: "foo;1.0\nbar;2.0\nbaz;3.0\n", opts: &Options{Stations: []string{"foo", "bar"}}, full: true},
+				{appendData: "bar;1.0\n", opts: &Options{Stations: []string{"bar", "foo"}}},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "measurements.txt")
+			statePath := filepath.Join(dir, "state")
+
+			var contents []byte
+			for i, s := range tc.steps {
+				switch {
+				case s.replace != "":
+					contents = []byte(s.replace)
+					tmp := filepath.Join(dir, "new.txt")
+					if err := os.WriteFile(tmp, contents, 0o600); err != nil {
+						t.Fatalf("WriteFile: %v", err)
+					}
+					if err := os.Rename(tmp, path); err != nil {
+						t.Fatalf("Rename: %v", err)
+					}
+				case s.write != "":
+					contents = []byte(s.write)
+					if err := os.WriteFile(path, contents, 0o600); err != nil {
+						t.Fatalf("WriteFile: %v", err)
+					}
+				default:
+					contents = append(contents, s.appendData...)
+					if err := os.WriteFile(path, contents, 0o600); err != nil {
+						t.Fatalf("WriteFile: %v", err)
+					}
+				}
+
+				opts := s.opts
+				if opts == nil {
+					opts = &Options{}
+				}
+				var logged bool
+				got, err := processFileIncremental(path, statePath, opts, func(string, ...any) {
+					logged = true
+				})
+				if err != nil {
+					t.Fatalf("step %d: processFileIncremental: %v", i, err)
+				}
+
+				// The result should match processing the complete lines.
+				complete := contents[:bytes.LastIndexByte(contents, '\n')+1]
+				want, err := processFile(bytes.NewReader(complete), chunkSize, opts)
+				if err != nil {
+					t.Fatalf("step %d: processFile: %v", i, err)
+				}
+				if diff := cmp.Diff(&partial{Scale: opts.valueScale(), UnitSuffix: opts.UnitSuffix, Stations: want}, got); diff != "" {
+					t.Fatalf("step %d: unexpected result (-want, +got):\n%s", i, diff)
+				}
+				if i > 0 && logged != s.full {
+					t.Fatalf("step %d: full run: want %v, got %v", i, s.full, logged)
+				}
+			}
+		})
+	}
+}
+
+func Test_readState(t *testing.T) {
+	t.Parallel()
+
+	s := &fileState{
+		Offset:      16,
+		Size:        20,
+		Inode:       1234,
+		HeadHash:    headHash([]byte("foo;1.0\nbar;2.0\n")),
+		OptionsHash: optionsHash(&Options{}),
+		Result: &partial{
+			Scale: 1,
+			Stations: map[string]*TempInfo{
+				"foo": {Min: 10, Max: 10, Sum: 10, Count: 1},
+				"bar": {Min: 20, Max: 20, Sum: 20, Count: 1},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeState(&buf, s); err != nil {
+		t.Fatalf("writeState: %v", err)
+	}
+	b := buf.Bytes()
+
+	got, err := readState(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("readState: %v", err)
+	}
+	if diff := cmp.Diff(s, got); diff != "" {
+		t.Fatalf("unexpected state (-want, +got):\n%s", diff)
+	}
+
+	corrupt := bytes.Clone(b)
+	corrupt[len(stateMagic)+3]++
+	for name, b := range map[string][]byte{
+		"corrupt":   corrupt,
+		"truncated": b[:len(b)-1],
+		"empty":     nil,
+	} {
+		if _, err := readState(bytes.NewReader(b)); !cmp.Equal(errStateFormat, err, cmpopts.EquateErrors()) {
+			t.Errorf("%s: want %v, got %v", name, errStateFormat, err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || linux || openbsd || solaris || netbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode number of the file described by fi or 0 if it
+// is not available.
+func fileInode(fi os.FileInfo) uint64 {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return uint64(st.Ino)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Temperature units. Values are aggregated in degrees Celsius.
+const (
+	unitCelsius    = "C"
+	unitFahrenheit = "F"
+	unitKelvin     = "K"
+)
+
+// validUnit returns true if unit is a known temperature unit.
+func validUnit(unit string) bool {
+	switch unit {
+	case unitCelsius, unitFahrenheit, unitKelvin:
+		return true
+	}
+	return false
+}
+
+// conversion returns num, offset and den such that (c*num + offset) / den
+// converts the value c in units of 10^-scale degrees Celsius to units of
+// 10^-scale of unit.
+func conversion(unit string, scale int) (num, offset, den int64) {
+	p := int64(math.Pow10(scale))
+	switch unit {
+	case unitFahrenheit:
+		// F = C*9/5 + 32
+		return 9, 160 * p, 5
+	case unitKelvin:
+		// K = C + 273.15
+		return 100, 27315 * p, 100
+	}
+	return 1, 0, 1
+}
+
+// fromCelsius returns the mean of count values with the given sum in units of
+// 10^-valueScale degrees Celsius converted to unit. The result is in units of
+// 10^-scale, where scale is at most valueScale, and is computed exactly and
+// then rounded half away from zero like round.
+func fromCelsius(unit string, sum, count, valueScale, scale int) int {
+	num, offset, den := conversion(unit, valueScale)
+
+	// (sum*num + offset*count) / (den*count*10^(valueScale-scale))
+	n := new(big.Int).Mul(big.NewInt(int64(sum)), big.NewInt(num))
+	n.Add(n, new(big.Int).Mul(big.NewInt(offset), big.NewInt(int64(count))))
+	d := new(big.Int).Mul(big.NewInt(den), big.NewInt(int64(count)))
+	d.Mul(d, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(valueScale-scale)), nil))
+
+	// Round half away from zero: (2|n| + d) / 2d.
+	neg := n.Sign() < 0
+	n.Abs(n)
+	n.Lsh(n, 1)
+	n.Add(n, d)
+	n.Quo(n, d.Lsh(d, 1))
+	if neg {
+		n.Neg(n)
+	}
+	return int(n.Int64())
+}
+
+// unitSuffix returns the value in field without a trailing unit suffix and the
+// unit it gives. Values without a suffix are in degrees Celsius.
+func unitSuffix(field []byte) ([]byte, string) {
+	if len(field) > 0 {
+		switch u := field[len(field)-1]; u {
+		case 'C', 'F', 'K':
+			return field[:len(field)-1], string(u)
+		}
+	}
+	return field, unitCelsius
+}
+
+// toCelsius converts the value v in units of 10^-scale of unit to units of
+// 10^-scale degrees Celsius, rounded half away from zero.
+func toCelsius(unit string, v, scale int) (int, error) {
+	if v > math.MaxInt64/100 || v < -math.MaxInt64/100 {
+		return 0, fmt.Errorf("%w: value out of range", errInputFormat)
+	}
+	num, offset, den := conversion(unit, scale)
+
+	// Invert the conversion from Celsius: (v*den - offset) / num.
+	n := int64(v)*den - offset
+	if n < 0 {
+		return int(-((-2*n + num) / (2 * num))), nil
+	}
+	return int((2*n + num) / (2 * num)), nil
+}
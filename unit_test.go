@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_fromCelsius(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		unit              string
+		sum, count        int
+		valueScale, scale int
+		expected          int
+	}{
+		"celsius": {
+			unit:       unitCelsius,
+			sum:        125,
+			count:      10,
+			valueScale: 1,
+			scale:      1,
+			expected:   13,
+		},
+		"fahrenheit": {
+			unit:       unitFahrenheit,
+			sum:        -150,
+			count:      1,
+			valueScale: 1,
+			scale:      1,
+			expected:   50,
+		},
+		"fahrenheit mean": {
+			// 1.25C is 34.25F.
+			unit:       unitFahrenheit,
+			sum:        50,
+			count:      4,
+			valueScale: 1,
+			scale:      1,
+			expected:   343,
+		},
+		"fahrenheit negative half": {
+			// -36.25C is -33.25F.
+			unit:       unitFahrenheit,
+			sum:        -1450,
+			count:      4,
+			valueScale: 1,
+			scale:      1,
+			expected:   -333,
+		},
+		"kelvin": {
+			// 0.0C is 273.15K.
+			unit:       unitKelvin,
+			sum:        0,
+			count:      1,
+			valueScale: 1,
+			scale:      1,
+			expected:   2732,
+		},
+		"kelvin scale": {
+			unit:       unitKelvin,
+			sum:        0,
+			count:      1,
+			valueScale: 2,
+			scale:      2,
+			expected:   27315,
+		},
+		"kelvin negative": {
+			// -273.2C is -0.05K.
+			unit:       unitKelvin,
+			sum:        -2732,
+			count:      1,
+			valueScale: 1,
+			scale:      1,
+			expected:   -1,
+		},
+		"kelvin value scale": {
+			// 26.950C is 300.100K.
+			unit:       unitKelvin,
+			sum:        26950,
+			count:      1,
+			valueScale: 3,
+			scale:      1,
+			expected:   3001,
+		},
+		"celsius value scale": {
+			unit:       unitCelsius,
+			sum:        -12350,
+			count:      1,
+			valueScale: 3,
+			scale:      1,
+			expected:   -124,
+		},
+		"large": {
+			unit:       unitFahrenheit,
+			sum:        999 * 1000000000,
+			count:      1000000000,
+			valueScale: 1,
+			scale:      1,
+			expected:   2118,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := fromCelsius(tc.unit, tc.sum, tc.count, tc.valueScale, tc.scale); got != tc.expected {
+				t.Fatalf("want %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func Test_toCelsius(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		unit     string
+		v        int
+		scale    int
+		expected int
+		err      error
+	}{
+		"celsius": {
+			unit:     unitCelsius,
+			v:        12340,
+			scale:    3,
+			expected: 12340,
+		},
+		"fahrenheit": {
+			// 50.000F is 10.000C.
+			unit:     unitFahrenheit,
+			v:        50000,
+			scale:    3,
+			expected: 10000,
+		},
+		"fahrenheit rounding": {
+			// 0.100F is -17.7222C.
+			unit:     unitFahrenheit,
+			v:        100,
+			scale:    3,
+			expected: -17722,
+		},
+		"kelvin": {
+			// 300.150K is exactly 27.000C.
+			unit:     unitKelvin,
+			v:        300150,
+			scale:    3,
+			expected: 27000,
+		},
+		"kelvin half": {
+			// 0.1K is -273.05C.
+			unit:     unitKelvin,
+			v:        1,
+			scale:    1,
+			expected: -2731,
+		},
+		"out of range": {
+			unit:  unitFahrenheit,
+			v:     999999999999999999,
+			scale: 1,
+			err:   errInputFormat,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := toCelsius(tc.unit, tc.v, tc.scale)
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+			if got != tc.expected {
+				t.Fatalf("want %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func Test_unitRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		chunk    string
+		unit     string
+		expected string
+	}{
+		"celsius": {
+			chunk:    "a;12.3C\na;-4.5C\n",
+			unit:     unitCelsius,
+			expected: "{a=-4.5/3.9/12.3}\n",
+		},
+		"fahrenheit": {
+			chunk:    "a;50.1F\na;-40.3F\n",
+			unit:     unitFahrenheit,
+			expected: "{a=-40.3/4.9/50.1}\n",
+		},
+		"kelvin": {
+			chunk:    "a;300.1K\na;273.2K\n",
+			unit:     unitKelvin,
+			expected: "{a=273.2/286.7/300.1}\n",
+		},
+		"kelvin single": {
+			chunk:    "a;300.1K\n",
+			unit:     unitKelvin,
+			expected: "{a=300.1/300.1/300.1}\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := &Options{Unit: tc.unit, UnitSuffix: true}
+			m, err := newParser(opts).processChunk([]byte(tc.chunk), 0)
+			if err != nil {
+				t.Fatalf("processChunk: %v", err)
+			}
+			var b strings.Builder
+			if err := printMap(&b, m, opts); err != nil {
+				t.Fatalf("printMap: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, b.String()); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
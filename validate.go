@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// maxStationLength is the maximum length in bytes of a station name in
+	// strict mode.
+	maxStationLength = 100
+
+	// maxStrictValue is the maximum absolute value in tenths in strict mode.
+	maxStrictValue = 999
+
+	// defaultMaxViolations is the default number of violations reported by
+	// the validate command.
+	defaultMaxViolations = 1000
+)
+
+var errValidation = errors.New("validation failed")
+
+// checkStation returns an error if name is not a valid station name in strict
+// mode.
+func checkStation(name []byte) error {
+	switch {
+	case len(name) == 0:
+		return fmt.Errorf("%w: empty station name", errInputFormat)
+	case len(name) > maxStationLength:
+		return fmt.Errorf("%w: station name longer than %d bytes", errInputFormat, maxStationLength)
+	case !utf8.Valid(name):
+		return fmt.Errorf("%w: station name %q is not valid UTF-8", errInputFormat, name)
+	}
+	return nil
+}
+
+// checkStationCount returns an error if there are too many unique stations in
+// m in strict mode.
+func checkStationCount(m map[string]*TempInfo, opts *Options) error {
+	if opts.Strict && len(m) > maxCities {
+		return fmt.Errorf("%w: %d unique stations exceeds maximum of %d", errInputFormat, len(m), maxCities)
+	}
+	return nil
+}
+
+// validateResult is the result of validating a file.
+type validateResult struct {
+	// Violations holds the first violations in the file ordered by offset.
+	Violations []*ParseError
+
+	// NumViolations is the total number of invalid lines.
+	NumViolations int
+
+	// Stations is the number of unique valid station names.
+	Stations int
+}
+
+// validateFile checks every line in the file at path against the 1 billion
+// row challenge rules, reporting at most maxViolations violations.
+func validateFile(path string, size int, opts *Options, maxViolations int) (*validateResult, error) {
+	strictOpts := *opts
+	strictOpts.Strict = true
+
+	f, data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer munmap(data)
+
+	parsers := make([]*parser, runtime.NumCPU())
+	for i := range parsers {
+		parsers[i] = newParser(&strictOpts)
+		parsers[i].collect = true
+		parsers[i].maxViolations = maxViolations
+	}
+
+	// Merge resulting maps to count the unique stations.
+	tempMap := make(map[string]*TempInfo, maxCities)
+	err = aggregateDataRandom(data, 0, size, parsers, func(m map[string]*TempInfo) error {
+		mergeMap(tempMap, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &validateResult{
+		Stations: len(tempMap),
+	}
+	for _, p := range parsers {
+		r.Violations = append(r.Violations, p.violations...)
+		r.NumViolations += p.numViolations
+	}
+	sort.Slice(r.Violations, func(i, j int) bool {
+		return r.Violations[i].Offset < r.Violations[j].Offset
+	})
+	if len(r.Violations) > maxViolations {
+		r.Violations = r.Violations[:maxViolations]
+	}
+	return r, nil
+}
+
+// validateCmd implements the validate command which reports lines in the
+// given files that violate the 1 billion row challenge rules.
+func validateCmd(args []string, opts *Options, w io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	maxViolations := fs.Int("max-violations", defaultMaxViolations, "maximum number of violations to report per file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("validate: no input files")
+	}
+
+	var failed bool
+	for _, path := range fs.Args() {
+		r, err := validateFile(path, segmentSize, opts, *maxViolations)
+		if err != nil {
+			return err
+		}
+		for _, v := range r.Violations {
+			fmt.Fprintf(w, "%s: %v\n", path, v)
+		}
+		if r.NumViolations > len(r.Violations) {
+			fmt.Fprintf(w, "%s: %d more violations not shown\n", path, r.NumViolations-len(r.Violations))
+		}
+		if r.Stations > maxCities {
+			fmt.Fprintf(w, "%s: %d unique stations exceeds maximum of %d\n", path, r.Stations, maxCities)
+			failed = true
+		}
+		if r.NumViolations > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		return errValidation
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_validateFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		path          string
+		maxViolations int
+		offsets       []int64
+		numViolations int
+		stations      int
+	}{
+		"valid": {
+			path:          "test/measurements-10.txt",
+			maxViolations: 100,
+			stations:      10,
+		},
+		"invalid": {
+			path:          "test/options/measurements-invalid.txt",
+			maxViolations: 100,
+			offsets:       []int64{13, 26, 31, 56, 63, 76, 102, 208},
+			numViolations: 8,
+			stations:      2,
+		},
+		"max violations": {
+			path:          "test/options/measurements-invalid.txt",
+			maxViolations: 3,
+			offsets:       []int64{13, 26, 31},
+			numViolations: 8,
+			stations:      2,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, size := range []int{8, 64, segmentSize} {
+				r, err := validateFile(tc.path, size, &Options{}, tc.maxViolations)
+				if err != nil {
+					t.Fatalf("validateFile(%d): %v", size, err)
+				}
+				var offsets []int64
+				for _, v := range r.Violations {
+					if !errors.Is(v, errInputFormat) {
+						t.Errorf("validateFile(%d): unexpected error: %v", size, v)
+					}
+					offsets = append(offsets, v.Offset)
+				}
+				if diff := cmp.Diff(tc.offsets, offsets); diff != "" {
+					t.Errorf("validateFile(%d): unexpected offsets (-want, +got):\n%s", size, diff)
+				}
+				if diff := cmp.Diff(tc.numViolations, r.NumViolations); diff != "" {
+					t.Errorf("validateFile(%d): unexpected number of violations (-want, +got):\n%s", size, diff)
+				}
+				if diff := cmp.Diff(tc.stations, r.Stations); diff != "" {
+					t.Errorf("validateFile(%d): unexpected number of stations (-want, +got):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_validateCmd(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		args   []string
+		output string
+		err    error
+	}{
+		"valid": {
+			args: []string{"test/measurements-10.txt", "test/measurements-boundaries.txt"},
+		},
+		"invalid": {
+			args: []string{"-max-violations=2", "test/options/measurements-invalid.txt"},
+			output: "test/options/measurements-invalid.txt: offset 13: bad input format: value \"12.25\" must have exactly one fractional digit\n" +
+				"test/options/measurements-invalid.txt: offset 26: bad input format: empty station name\n" +
+				"test/options/measurements-invalid.txt: 6 more violations not shown\n",
+			err: errValidation,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			err := validateCmd(tc.args, &Options{}, &buf)
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.output, buf.String()); diff != "" {
+				t.Fatalf("unexpected output (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_processFile_strictStationCount(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	for i := 0; i <= maxCities; i++ {
+		fmt.Fprintf(&b, "id%d;1.0\n", i)
+	}
+
+	_, err := processFile(strings.NewReader(b.String()), chunkSize, &Options{Strict: true})
+	if diff := cmp.Diff(errInputFormat, err, cmpopts.EquateErrors()); diff != "" {
+		t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+	}
+
+	m, err := processFile(strings.NewReader(b.String()), chunkSize, nil)
+	if err != nil {
+		t.Fatalf("processFile: %v", err)
+	}
+	if got, want := len(m), maxCities+1; got != want {
+		t.Fatalf("unexpected number of stations: got %d, want %d", got, want)
+	}
+}
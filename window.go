@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// windowLayout is the layout of window start times in windowed keys.
+const windowLayout = "2006-01-02T15:04:05Z"
+
+// windowKeyLen is the length of the window start time at the beginning of
+// windowed keys. Windowed keys are the start time of the window followed by
+// the station name so that they sort by window and then station.
+const windowKeyLen = len(windowLayout)
+
+// parseTimestamp parses an RFC 3339 timestamp and returns the Unix time in
+// seconds.
+func parseTimestamp(b []byte) (int64, error) {
+	// Parse timestamps in UTC with no fractional seconds without
+	// allocating.
+	if len(b) == len(windowLayout) && b[4] == '-' && b[7] == '-' && b[10] == 'T' &&
+		b[13] == ':' && b[16] == ':' && b[19] == 'Z' {
+		year, ok1 := atoi(b[0:4])
+		month, ok2 := atoi(b[5:7])
+		day, ok3 := atoi(b[8:10])
+		hour, ok4 := atoi(b[11:13])
+		minute, ok5 := atoi(b[14:16])
+		sec, ok6 := atoi(b[17:19])
+		if ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && month >= 1 && month <= 12 &&
+			hour < 24 && minute < 60 && sec < 60 {
+			t := time.Date(year, time.Month(month), day, hour, minute, sec, 0, time.UTC)
+			if t.Day() == day {
+				return t.Unix(), nil
+			}
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, string(b))
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid timestamp %q", errInputFormat, b)
+	}
+	return t.Unix(), nil
+}
+
+// atoi parses b as an unsigned decimal integer.
+func atoi(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, len(b) > 0
+}
+
+// windowStart returns the start of the window of the given size in seconds
+// containing the Unix time sec.
+func windowStart(sec, size int64) int64 {
+	start := sec - sec%size
+	if sec%size < 0 {
+		start -= size
+	}
+	return start
+}
+
+// appendWindowKey appends the windowed key for the station name in the window
+// starting at the Unix time start to b.
+func appendWindowKey(b []byte, start int64, name []byte) ([]byte, error) {
+	t := time.Unix(start, 0).UTC()
+	if t.Year() < 0 || t.Year() > 9999 {
+		return b, fmt.Errorf("%w: timestamp out of range", errInputFormat)
+	}
+	b = t.AppendFormat(b, windowLayout)
+	return append(b, name...), nil
+}
+
+// splitWindowKey splits a windowed key into the window start time and station
+// name.
+func splitWindowKey(key string) (window, name string) {
+	if len(key) < windowKeyLen {
+		return "", key
+	}
+	return key[:windowKeyLen], key[windowKeyLen:]
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_parseTimestamp(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s   string
+		sec int64
+		err error
+	}{
+		"utc": {
+			s:   "2026-10-17T12:00:00Z",
+			sec: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC).Unix(),
+		},
+		"offset": {
+			s:   "2026-10-17T14:00:00+02:00",
+			sec: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC).Unix(),
+		},
+		"fractional seconds": {
+			s:   "2026-10-17T12:00:00.999Z",
+			sec: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC).Unix(),
+		},
+		"leap day": {
+			s:   "2024-02-29T00:00:00Z",
+			sec: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC).Unix(),
+		},
+		"before epoch": {
+			s:   "1969-12-31T23:59:59Z",
+			sec: -1,
+		},
+		"invalid day": {
+			s:   "2026-02-30T12:00:00Z",
+			err: errInputFormat,
+		},
+		"invalid hour": {
+			s:   "2026-10-17T24:00:00Z",
+			err: errInputFormat,
+		},
+		"missing zone": {
+			s:   "2026-10-17T12:00:00",
+			err: errInputFormat,
+		},
+		"unix time": {
+			s:   "1792238400",
+			err: errInputFormat,
+		},
+		"empty": {
+			s:   "",
+			err: errInputFormat,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			sec, err := parseTimestamp([]byte(tc.s))
+			if diff := cmp.Diff(tc.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("unexpected error (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.sec, sec); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_windowStart(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		sec, size, start int64
+	}{
+		{sec: 0, size: 3600, start: 0},
+		{sec: 3599, size: 3600, start: 0},
+		{sec: 3600, size: 3600, start: 3600},
+		{sec: -1, size: 3600, start: -3600},
+		{sec: -3600, size: 3600, start: -3600},
+	}
+	for _, tc := range testCases {
+		if got := windowStart(tc.sec, tc.size); got != tc.start {
+			t.Errorf("windowStart(%d, %d): want %d, got %d", tc.sec, tc.size, tc.start, got)
+		}
+	}
+}
+
+func Test_parseWindow(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s   string
+		d   time.Duration
+		err bool
+	}{
+		"empty": {
+			s: "",
+		},
+		"hour": {
+			s: "1h",
+			d: time.Hour,
+		},
+		"minutes": {
+			s: "15m",
+			d: 15 * time.Minute,
+		},
+		"day": {
+			s: "1d",
+			d: 24 * time.Hour,
+		},
+		"week": {
+			s: "7d",
+			d: 7 * 24 * time.Hour,
+		},
+		"zero": {
+			s:   "0s",
+			err: true,
+		},
+		"negative": {
+			s:   "-1h",
+			err: true,
+		},
+		"bad days": {
+			s:   "xd",
+			err: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := parseWindow(tc.s)
+			if got := err != nil; got != tc.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.d, d); diff != "" {
+				t.Fatalf("unexpected result (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}